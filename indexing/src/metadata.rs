@@ -104,6 +104,20 @@ pub fn extract_exif(path: &String) -> (Option<JsonValue>, Option<NaiveDateTime>)
                     EntryValue::URational(rational) => {
                         exif_json[name] = array![rational.0, rational.1]
                     }
+                    EntryValue::URationalArray(rationals) => {
+                        exif_json[name] = rationals
+                            .iter()
+                            .map(|rational| array![rational.0, rational.1])
+                            .collect::<Vec<JsonValue>>()
+                            .into()
+                    }
+                    EntryValue::IRationalArray(rationals) => {
+                        exif_json[name] = rationals
+                            .iter()
+                            .map(|rational| array![rational.0, rational.1])
+                            .collect::<Vec<JsonValue>>()
+                            .into()
+                    }
                     EntryValue::F32(f) => exif_json[name] = (*f).into(),
                     EntryValue::F64(f) => exif_json[name] = (*f).into(),
                     EntryValue::U8(u) => exif_json[name] = (*u).into(),
@@ -144,7 +158,7 @@ pub fn extract_exif(path: &String) -> (Option<JsonValue>, Option<NaiveDateTime>)
                     }
                     _ => {
                         // TODO: Consider adding support for commonly used values:
-                        // U16Array, URationalArray
+                        // U16Array
                     }
                 }
             }
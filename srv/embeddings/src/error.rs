@@ -25,6 +25,18 @@ pub async fn handle_rejection(err: Rejection) -> std::result::Result<impl Reply,
     } else if err.find::<crate::handler::InvalidQueryError>().is_some() {
         code = StatusCode::BAD_REQUEST;
         message = "The query must not be empty or very large.";
+    } else if err.find::<warp::reject::PayloadTooLarge>().is_some() {
+        code = StatusCode::PAYLOAD_TOO_LARGE;
+        message = "Payload Too Large";
+    } else if err.find::<crate::handler::InvalidImageError>().is_some() {
+        code = StatusCode::BAD_REQUEST;
+        message = "The image must be a JPEG image.";
+    } else if err
+        .find::<crate::handler::ImageModelNotConfiguredError>()
+        .is_some()
+    {
+        code = StatusCode::NOT_IMPLEMENTED;
+        message = "No image model is configured.";
     } else if let Some(server_err) = err.find::<crate::handler::ServerError>() {
         code = StatusCode::INTERNAL_SERVER_ERROR;
         message = server_err.message.as_str();
@@ -9,6 +9,7 @@ use warp::{
 };
 
 use crate::embedding;
+use crate::image_embedding;
 
 pub async fn health_handler() -> Result<impl Reply, Rejection> {
     Ok(json(&HealthResponse {
@@ -42,6 +43,39 @@ pub async fn create_embedding_handler(
     Ok(json(&CreateEmbeddingResponse { v: embedding }))
 }
 
+pub async fn create_image_embedding_handler(
+    body: bytes::Bytes,
+    context: Arc<Option<Mutex<image_embedding::ImageModel>>>,
+) -> Result<impl Reply, Rejection> {
+    let context = match context.as_ref() {
+        None => return Err(reject::custom(ImageModelNotConfiguredError())),
+        Some(context) => context,
+    };
+    if body.is_empty() {
+        return Err(reject::custom(InvalidImageError()));
+    }
+
+    let context = context.lock().unwrap();
+    let now = std::time::Instant::now();
+    let embedding = match context.calc_embedding(&body) {
+        Err(e) if e.is::<image_embedding::InvalidImageError>() => {
+            eprintln!("{}", e);
+            return Err(reject::custom(InvalidImageError()));
+        }
+        Err(_) => {
+            return Err(reject::custom(ServerError {
+                message: "embedding calcuation failed".to_string(),
+            }));
+        }
+
+        Ok(e) => e,
+    };
+    let elapsed = now.elapsed();
+    println!("Handled image of {} bytes in {:.2?}", body.len(), elapsed);
+
+    Ok(json(&CreateEmbeddingResponse { v: embedding }))
+}
+
 // Request / Response
 
 #[derive(Deserialize, Clone, PartialEq, Debug)]
@@ -66,6 +100,16 @@ pub struct InvalidQueryError();
 
 impl Reject for InvalidQueryError {}
 
+#[derive(Debug)]
+pub struct InvalidImageError();
+
+impl Reject for InvalidImageError {}
+
+#[derive(Debug)]
+pub struct ImageModelNotConfiguredError();
+
+impl Reject for ImageModelNotConfiguredError {}
+
 #[derive(Debug)]
 pub struct ServerError {
     pub message: String,
@@ -0,0 +1,84 @@
+use std::path::Path;
+
+use anyhow::{Error as E, Result};
+use candle_core::{DType, Device, IndexOp, Tensor};
+use candle_nn::VarBuilder;
+use candle_transformers::models::clip;
+
+/// The CLIP model used by the indexing tool to calculate the embeddings of
+/// photos, such that images can be searched by in the same vector space.
+pub struct ImageModel {
+    device: Device,
+    model: clip::ClipModel,
+    config: clip::ClipConfig,
+}
+
+impl ImageModel {
+    pub fn new(device: Device, base_path: &str) -> Result<Self> {
+        let files_root = Path::new(base_path);
+        if !files_root.exists() {
+            return Err(E::msg(format!("The path '{}' does not exist.", base_path)));
+        } else if !files_root.is_dir() {
+            return Err(E::msg(format!(
+                "The path '{}' is not a directory.",
+                base_path
+            )));
+        }
+
+        let model_path = files_root.join(Path::new("model.safetensors"));
+        let vb =
+            unsafe { VarBuilder::from_mmaped_safetensors(&[model_path], DType::F32, &device)? };
+
+        let config = clip::ClipConfig::vit_base_patch32();
+        let model = clip::ClipModel::new(vb, &config)?;
+
+        Ok(ImageModel {
+            device,
+            model,
+            config,
+        })
+    }
+
+    pub fn calc_embedding(&self, data: &[u8]) -> Result<Vec<f32>> {
+        let pixel_values = self.load_image(data)?.unsqueeze(0)?;
+        let embeddings = self.model.get_image_features(&pixel_values)?;
+        let embedding = embeddings.i(0)?.to_vec1::<f32>()?;
+
+        Ok(embedding)
+    }
+
+    /// Decodes the image and prepares it the same way the indexing tool does.
+    fn load_image(&self, data: &[u8]) -> Result<Tensor> {
+        let img = image::load_from_memory(data)
+            .map_err(|e| InvalidImageError(format!("Failed to decode image: {}", e)))?;
+
+        // The model expects images of 224x224, so resize the image accordingly.
+        let image_size = u32::try_from(self.config.image_size)?;
+        let img = img.resize_to_fill(
+            image_size,
+            image_size,
+            image::imageops::FilterType::Triangle,
+        );
+        let img = img.to_rgb8().into_raw();
+
+        let (height, width) = (self.config.image_size, self.config.image_size);
+        let img = Tensor::from_vec(img, (height, width, 3), &self.device)?
+            .permute((2, 0, 1))?
+            .to_dtype(DType::F32)?
+            .affine(2. / 255., -1.)?;
+
+        Ok(img)
+    }
+}
+
+/// The error for images which cannot be decoded.
+#[derive(Debug)]
+pub struct InvalidImageError(pub String);
+
+impl std::fmt::Display for InvalidImageError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}", self.0)
+    }
+}
+
+impl std::error::Error for InvalidImageError {}
@@ -13,6 +13,10 @@ use warp::Filter;
 mod embedding;
 mod error;
 mod handler;
+mod image_embedding;
+
+// The maximum size of images to calculate embeddings for.
+const MAX_IMAGE_SIZE: u64 = 20 << 20;
 
 #[derive(Parser, Debug)]
 #[command(author, version, about, long_about = None)]
@@ -20,6 +24,12 @@ struct Args {
     #[arg(short, long, help = "Path to the model to use")]
     model_path: String,
 
+    #[arg(
+        long,
+        help = "Path to the CLIP model used for indexing, to calculate embeddings for images; images are not supported if not set"
+    )]
+    image_model_path: Option<String>,
+
     #[arg(
         short,
         long,
@@ -38,8 +48,19 @@ async fn main() -> Result<()> {
     let model = embedding::Model::new(device, args.model_path.as_str())?;
     println!("Model successfully loaded.");
 
+    let image_model = match args.image_model_path {
+        Some(path) => {
+            println!("Loading image model from '{}'...", path);
+            let image_model = image_embedding::ImageModel::new(Device::Cpu, path.as_str())?;
+            println!("Image model successfully loaded.");
+            Some(image_model)
+        }
+        None => None,
+    };
+
     let health = warp::path!("_health");
     let embed = warp::path!("v1" / "embed");
+    let embed_image = warp::path!("v1" / "embed" / "image");
 
     let health_routes = health.and(warp::get()).and_then(handler::health_handler);
 
@@ -49,8 +70,16 @@ async fn main() -> Result<()> {
         .and(with_model(model))
         .and_then(handler::create_embedding_handler);
 
+    let embed_image_routes = embed_image
+        .and(warp::post())
+        .and(warp::body::content_length_limit(MAX_IMAGE_SIZE))
+        .and(warp::body::bytes())
+        .and(with_image_model(image_model))
+        .and_then(handler::create_image_embedding_handler);
+
     let routes = health_routes
         .or(embed_routes)
+        .or(embed_image_routes)
         .recover(error::handle_rejection);
 
     println!("Starting server on {} ...", args.binding);
@@ -66,3 +95,11 @@ fn with_model(
     let context = Arc::new(Mutex::new(model));
     warp::any().map(move || context.clone())
 }
+
+fn with_image_model(
+    model: Option<image_embedding::ImageModel>,
+) -> impl Filter<Extract = (Arc<Option<Mutex<image_embedding::ImageModel>>>,), Error = Infallible> + Clone
+{
+    let context = Arc::new(model.map(Mutex::new));
+    warp::any().map(move || context.clone())
+}
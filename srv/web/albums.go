@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// ALBUM_ID_SIZE is the number of random bytes in album IDs.
+	ALBUM_ID_SIZE = 8
+)
+
+// listAlbums lists all albums ordered by name, without the IDs of their
+// photos.
+func (s *localStore) listAlbums() ([]*models.Album, error) {
+	albums := []*models.Album{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(ALBUMS_BUCKET)).ForEach(func(_, v []byte) error {
+			album := &models.Album{}
+			if err := json.Unmarshal(v, album); nil != err {
+				return err
+			}
+
+			album.PhotoCount = len(album.PhotoIds)
+			album.PhotoIds = nil
+			albums = append(albums, album)
+
+			return nil
+		})
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].Name != albums[j].Name {
+			return albums[i].Name < albums[j].Name
+		}
+		return albums[i].Id < albums[j].Id
+	})
+
+	return albums, nil
+}
+
+func (s *localStore) getAlbum(id string) (*models.Album, error) {
+	var album *models.Album
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		album, err = readAlbum(tx, id)
+		return err
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return album, nil
+}
+
+func (s *localStore) createAlbum(album *models.Album) (*models.Album, error) {
+	id := make([]byte, ALBUM_ID_SIZE)
+	if _, err := rand.Read(id); nil != err {
+		return nil, err
+	}
+
+	album.Id = hex.EncodeToString(id)
+	album.Created = time.Now().Unix()
+	album.Updated = album.Created
+	album.PhotoIds = []string{}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return writeAlbum(tx, album)
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return album, nil
+}
+
+// updateAlbum applies the given update to the album with the given ID, and stores
+// the updated album if the update succeeds.
+func (s *localStore) updateAlbum(id string, update func(album *models.Album) error) (*models.Album, error) {
+	var album *models.Album
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if album, err = readAlbum(tx, id); nil != err {
+			return err
+		}
+
+		if err = update(album); nil != err {
+			return err
+		}
+		album.Updated = time.Now().Unix()
+
+		return writeAlbum(tx, album)
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return album, nil
+}
+
+func (s *localStore) deleteAlbum(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(ALBUMS_BUCKET))
+		if nil == bucket.Get([]byte(id)) {
+			return AlbumNotFound
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func readAlbum(tx *bolt.Tx, id string) (*models.Album, error) {
+	v := tx.Bucket([]byte(ALBUMS_BUCKET)).Get([]byte(id))
+	if nil == v {
+		return nil, AlbumNotFound
+	}
+
+	album := &models.Album{}
+	if err := json.Unmarshal(v, album); nil != err {
+		return nil, err
+	}
+	album.PhotoCount = len(album.PhotoIds)
+
+	return album, nil
+}
+
+func writeAlbum(tx *bolt.Tx, album *models.Album) error {
+	album.PhotoCount = len(album.PhotoIds)
+	v, err := json.Marshal(album)
+	if nil != err {
+		return err
+	}
+
+	return tx.Bucket([]byte(ALBUMS_BUCKET)).Put([]byte(album.Id), v)
+}
+
+func (c *serverContext) createAlbum(req *models.AlbumRequest) (*models.Album, error) {
+	if nil == req.Name || "" == strings.TrimSpace(*req.Name) {
+		return nil, InvalidAlbum
+	}
+
+	album := &models.Album{Name: strings.TrimSpace(*req.Name)}
+	if nil != req.Description {
+		album.Description = *req.Description
+	}
+
+	return c.local.createAlbum(album)
+}
+
+func (c *serverContext) updateAlbum(id string, req *models.AlbumRequest) (*models.Album, error) {
+	if nil != req.Name && "" == strings.TrimSpace(*req.Name) {
+		return nil, InvalidAlbum
+	}
+
+	return c.local.updateAlbum(id, func(album *models.Album) error {
+		if nil != req.Name {
+			album.Name = strings.TrimSpace(*req.Name)
+		}
+		if nil != req.Description {
+			album.Description = *req.Description
+		}
+
+		return nil
+	})
+}
+
+// addAlbumPhotos adds the photos with the given IDs to the album, after the
+// photos already in it.
+func (c *serverContext) addAlbumPhotos(ctx context.Context, id string, photoIds []string) (*models.Album, error) {
+	points, err := c.getPoints(ctx, photoIds, METADATA_PATH)
+	if nil != err {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(points))
+	for _, point := range points {
+		found[point.Id.GetUuid()] = true
+	}
+	for _, photoId := range photoIds {
+		if !found[photoId] {
+			glog.V(1).Infof("Cannot add photo '%s' to album '%s': not found", photoId, id)
+			return nil, PhotoNotFound
+		}
+	}
+
+	return c.local.updateAlbum(id, func(album *models.Album) error {
+		for _, photoId := range photoIds {
+			if !slices.Contains(album.PhotoIds, photoId) {
+				album.PhotoIds = append(album.PhotoIds, photoId)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (c *serverContext) removeAlbumPhotos(id string, photoIds []string) (*models.Album, error) {
+	return c.local.updateAlbum(id, func(album *models.Album) error {
+		album.PhotoIds = slices.DeleteFunc(album.PhotoIds, func(photoId string) bool {
+			return slices.Contains(photoIds, photoId)
+		})
+
+		return nil
+	})
+}
+
+// makeAlbumCondition makes a condition for the photos in the album with the
+// given ID.
+func (c *serverContext) makeAlbumCondition(id string) (*pb.Condition, error) {
+	album, err := c.local.getAlbum(id)
+	if nil != err {
+		return nil, err
+	}
+
+	return makeHasIdCondition(album.PhotoIds), nil
+}
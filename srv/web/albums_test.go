@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestAddAlbumPhotos(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestMemoryStore(t, filepath.Join(dir, "photos.db"))
+	ids := make([]string, POINTS_PAGE_SIZE+2)
+	points := make([]*pb.PointStruct, len(ids))
+	for i := range ids {
+		ids[i] = testPointId(i)
+		points[i] = testPoint(ids[i], testVector(0, 1), map[string]any{METADATA_PATH: ids[i]})
+	}
+	if err := store.Upsert(points); nil != err {
+		t.Fatalf("Upsert() failed: %v", err)
+	}
+
+	local, err := openLocalStore(filepath.Join(dir, "local.db"))
+	if nil != err {
+		t.Fatalf("openLocalStore() failed: %v", err)
+	}
+	defer local.Close()
+
+	c := &serverContext{store: store, local: local}
+	name := "album"
+	album, err := c.createAlbum(&models.AlbumRequest{Name: &name})
+	if nil != err {
+		t.Fatalf("createAlbum() failed: %v", err)
+	}
+
+	// Photos are checked in batches, and missing ones are rejected.
+	missing := append(slices.Clone(ids), testPointId(len(ids)))
+	if _, err := c.addAlbumPhotos(context.Background(), album.Id, missing); PhotoNotFound != err {
+		t.Errorf("addAlbumPhotos() with missing photo returned %v, expected %v", err, PhotoNotFound)
+	}
+
+	album, err = c.addAlbumPhotos(context.Background(), album.Id, append(slices.Clone(ids), ids[0]))
+	if nil != err {
+		t.Fatalf("addAlbumPhotos() failed: %v", err)
+	} else if !slices.Equal(ids, album.PhotoIds) {
+		t.Errorf("album has %d photos, expected %d", len(album.PhotoIds), len(ids))
+	}
+}
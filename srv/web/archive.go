@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	// MAX_ARCHIVE_PHOTOS is the maximum number of photos in a single archive.
+	MAX_ARCHIVE_PHOTOS = 1000
+	// MAX_ARCHIVE_DIMENSION is the largest maximum dimension photos can be
+	// resized to.
+	MAX_ARCHIVE_DIMENSION = 16384
+	// ARCHIVE_JPEG_QUALITY is the quality of photos which are re-encoded for
+	// archives; it's higher than that of thumbnails, since the photos are
+	// meant to be kept.
+	ARCHIVE_JPEG_QUALITY = 90
+	// ARCHIVE_ERRORS_FILE is the file listing the entries which were skipped,
+	// and why.
+	ARCHIVE_ERRORS_FILE = "errors.txt"
+)
+
+// archiveEntry is a photo to add to an archive.
+type archiveEntry struct {
+	relPath     string
+	name        string
+	mediaType   string
+	orientation *int64
+	// reencode tells whether a photo is to be re-encoded as JPEG.
+	reencode bool
+	// keepIfFits tells whether a re-encoded photo is to be copied as it is
+	// if it fits into the maximum dimension already.
+	keepIfFits bool
+}
+
+// prepareArchive validates the archive request, and gets the entries of the
+// archive in the order of the requested IDs, before anything is streamed.
+func (c *serverContext) prepareArchive(
+	ctx context.Context,
+	req *models.ArchivePhotosRequest,
+) ([]*archiveEntry, error) {
+	ids := uniqueIds(req.Ids)
+	if len(ids) < 1 || len(ids) > MAX_ARCHIVE_PHOTOS ||
+		(nil != req.MaxDimension && (*req.MaxDimension < 1 || *req.MaxDimension > MAX_ARCHIVE_DIMENSION)) {
+		return nil, InvalidArchiveRequest
+	}
+
+	points, err := c.getPoints(ctx, ids, METADATA_PATH, METADATA_MEDIA_TYPE, METADATA_EXIF)
+	if nil != err {
+		return nil, err
+	} else if len(points) != len(ids) {
+		return nil, PhotoNotFound
+	}
+
+	payloads := make(map[string]map[string]*pb.Value, len(points))
+	for _, point := range points {
+		payloads[point.Id.GetUuid()] = point.Payload
+	}
+
+	entries := make([]*archiveEntry, 0, len(ids))
+	names := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		payload := payloads[id]
+		entry := &archiveEntry{
+			relPath:     *getPathFromPayload(payload),
+			mediaType:   getMediaTypeFromPayload(payload),
+			orientation: getOrientationFromPayload(payload),
+		}
+
+		name := path.Base(entry.relPath)
+		if req.KeepFolders {
+			name = archiveEntryPath(entry.relPath)
+		}
+		if MEDIA_TYPE_VIDEO != entry.mediaType &&
+			(nil != req.MaxDimension || req.StripMetadata) {
+			// Re-encoded photos are always JPEGs.
+			ext := strings.ToLower(path.Ext(name))
+			isJpeg := ".jpg" == ext || ".jpeg" == ext
+			if !isJpeg {
+				name = strings.TrimSuffix(name, path.Ext(name)) + ".jpg"
+			}
+			entry.reencode = true
+			entry.keepIfFits = isJpeg && !req.StripMetadata
+		}
+		entry.name = uniqueArchiveName(name, names)
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// archiveEntryPath gets the path of a photo in an archive which keeps the
+// folders, such that it can't point outside of the archive's root.
+func archiveEntryPath(relPath string) string {
+	p := path.Clean("/" + strings.ReplaceAll(relPath, "\\", "/"))
+	return strings.TrimPrefix(p, "/")
+}
+
+// uniqueArchiveName makes the given name unique among the names used so far,
+// by appending a number to it if needed.
+func uniqueArchiveName(name string, names map[string]bool) string {
+	unique := name
+	ext := path.Ext(name)
+	for i := 2; names[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	names[strings.ToLower(unique)] = true
+
+	return unique
+}
+
+// writeArchive writes the ZIP archive of the given entries. Photos are either
+// copied as they are, or resized and/or re-encoded, which drops all their
+// metadata. Videos are copied as they are, unless their metadata is to be
+// stripped, in which case they are skipped. Photos which can't be read are
+// skipped too, and all skipped entries are listed in ARCHIVE_ERRORS_FILE at
+// the end of the archive. The flush function is called after each entry.
+func (c *serverContext) writeArchive(
+	w io.Writer,
+	entries []*archiveEntry,
+	req *models.ArchivePhotosRequest,
+	flush func(),
+) error {
+	zw := zip.NewWriter(w)
+
+	var skipped []string
+	for _, entry := range entries {
+		if MEDIA_TYPE_VIDEO == entry.mediaType && req.StripMetadata {
+			glog.V(1).Infof("Skipping video '%s' in archive, its metadata can't be stripped.", entry.relPath)
+			skipped = append(skipped, entry.name+": the metadata of videos can't be stripped")
+			continue
+		}
+
+		// Everything which can fail for a single photo is done before its
+		// entry is started, so it can be skipped.
+		absPath := path.Join(c.photosRootDir, entry.relPath)
+		f, err := os.Open(absPath)
+		if nil != err {
+			glog.Errorf("Failed to open '%s' for archive: %v", entry.relPath, err)
+			skipped = append(skipped, entry.name+": the file can't be read")
+			continue
+		}
+
+		var data []byte
+		fi, err := f.Stat()
+		if nil == err && entry.reencode {
+			data, err = renderArchivePhoto(f, absPath, entry, req.MaxDimension)
+		}
+		if nil != err {
+			f.Close()
+			glog.Errorf("Failed to prepare '%s' for archive: %v", entry.relPath, err)
+			skipped = append(skipped, entry.name+": the photo can't be read")
+			continue
+		}
+
+		err = writeArchiveEntry(zw, entry.name, fi.ModTime(), f, data)
+		f.Close()
+		if nil != err {
+			glog.Errorf("Failed to write '%s' to archive: %v", entry.relPath, err)
+			return err
+		}
+
+		flush()
+	}
+
+	if len(skipped) > 0 {
+		manifest := strings.NewReader(strings.Join(skipped, "\n") + "\n")
+		if err := writeArchiveEntry(zw, ARCHIVE_ERRORS_FILE, time.Now(), manifest, nil); nil != err {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeArchiveEntry writes an entry with the given data, or the data read from
+// the reader if it's nil.
+func writeArchiveEntry(zw *zip.Writer, name string, modified time.Time, r io.Reader, data []byte) error {
+	// Photos and videos are compressed already.
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	})
+	if nil != err {
+		return err
+	}
+
+	if nil != data {
+		_, err = fw.Write(data)
+	} else {
+		_, err = io.Copy(fw, r)
+	}
+
+	return err
+}
+
+// renderArchivePhoto re-encodes the photo as JPEG, shrunk to fit into the
+// maximum dimension, if any. For JPEG photos which fit already and whose
+// metadata is kept, nil is returned such that the original is copied; their
+// dimensions are read without decoding them.
+func renderArchivePhoto(f *os.File, absPath string, entry *archiveEntry, maxDimension *int) ([]byte, error) {
+	if entry.keepIfFits {
+		config, _, err := image.DecodeConfig(f)
+		if nil == err && max(config.Width, config.Height) <= *maxDimension {
+			_, err = f.Seek(0, io.SeekStart)
+			return nil, err
+		}
+	}
+
+	decoder := getImageDecoder(absPath)
+	img, err := decoder.decode(absPath)
+	if nil != err {
+		glog.Errorf("Failed to open photo file '%s' using %s decoder: %v", absPath, decoder.name, err)
+		return nil, err
+	}
+
+	fits := nil == maxDimension ||
+		max(img.Bounds().Dx(), img.Bounds().Dy()) <= *maxDimension
+	if fits && entry.keepIfFits {
+		_, err = f.Seek(0, io.SeekStart)
+		return nil, err
+	}
+
+	if !decoder.autoOriented && nil != entry.orientation {
+		// Without metadata, the orientation needs to be applied to the pixels.
+		img = realignImage(img, *entry.orientation)
+	}
+	if !fits {
+		img = imaging.Fit(img, *maxDimension, *maxDimension, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: ARCHIVE_JPEG_QUALITY}); nil != err {
+		glog.Errorf("Failed to encode '%s' for archive: %v", absPath, err)
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
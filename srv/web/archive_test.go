@@ -0,0 +1,124 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestArchiveEntryPath(t *testing.T) {
+	tests := []struct {
+		relPath  string
+		expected string
+	}{
+		{"2024/summer/a.jpg", "2024/summer/a.jpg"},
+		{"/2024/a.jpg", "2024/a.jpg"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"2024/../../a.jpg", "a.jpg"},
+		{"2024\\summer\\a.jpg", "2024/summer/a.jpg"},
+		{"..\\..\\a.jpg", "a.jpg"},
+		{"2024//./a.jpg", "2024/a.jpg"},
+	}
+
+	for _, test := range tests {
+		if actual := archiveEntryPath(test.relPath); test.expected != actual {
+			t.Errorf("archiveEntryPath(%q) = %q, expected %q", test.relPath, actual, test.expected)
+		}
+	}
+}
+
+func TestUniqueArchiveName(t *testing.T) {
+	names := make(map[string]bool)
+	given := []string{"a.jpg", "a.jpg", "A.JPG", "b.jpg", "a (2).jpg", "a.jpg", "noext", "noext"}
+	expected := []string{"a.jpg", "a (2).jpg", "A (3).JPG", "b.jpg", "a (2) (2).jpg", "a (4).jpg", "noext", "noext (2)"}
+
+	actual := make([]string, len(given))
+	for i, name := range given {
+		actual[i] = uniqueArchiveName(name, names)
+	}
+
+	if !slices.Equal(expected, actual) {
+		t.Errorf("uniqueArchiveName() = %v, expected %v", actual, expected)
+	}
+}
+
+func TestWriteArchiveSkipsUnreadableEntries(t *testing.T) {
+	dir := t.TempDir()
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, image.NewGray(image.Rect(0, 0, 40, 20)), nil); nil != err {
+		t.Fatalf("jpeg.Encode() failed: %v", err)
+	}
+	files := map[string][]byte{"a.jpg": photo.Bytes(), "broken.jpg": []byte("not a photo"), "v.mp4": []byte("video")}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); nil != err {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	maxDimension := 100
+	c := &serverContext{photosRootDir: dir}
+	tests := []struct {
+		strip    bool
+		expected []string
+		skipped  []string
+	}{
+		{false, []string{"a.jpg", "v.mp4", ARCHIVE_ERRORS_FILE}, []string{"missing.jpg", "broken.jpg"}},
+		{true, []string{"a.jpg", ARCHIVE_ERRORS_FILE}, []string{"missing.jpg", "broken.jpg", "v.mp4"}},
+	}
+
+	for _, test := range tests {
+		entries := []*archiveEntry{
+			{relPath: "a.jpg", name: "a.jpg", mediaType: MEDIA_TYPE_PHOTO, reencode: true, keepIfFits: !test.strip},
+			{relPath: "missing.jpg", name: "missing.jpg", mediaType: MEDIA_TYPE_PHOTO},
+			{relPath: "broken.jpg", name: "broken.jpg", mediaType: MEDIA_TYPE_PHOTO, reencode: true, keepIfFits: !test.strip},
+			{relPath: "v.mp4", name: "v.mp4", mediaType: MEDIA_TYPE_VIDEO},
+		}
+
+		var buf bytes.Buffer
+		req := &models.ArchivePhotosRequest{MaxDimension: &maxDimension, StripMetadata: test.strip}
+		if err := c.writeArchive(&buf, entries, req, func() {}); nil != err {
+			t.Fatalf("writeArchive() failed: %v", err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if nil != err {
+			t.Fatalf("zip.NewReader() failed: %v", err)
+		}
+
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if !slices.Equal(test.expected, names) {
+			t.Errorf("Archive with stripMetadata %t has %v, expected %v", test.strip, names, test.expected)
+		}
+
+		r, err := zr.Open(ARCHIVE_ERRORS_FILE)
+		if nil != err {
+			t.Fatalf("Open(%s) failed: %v", ARCHIVE_ERRORS_FILE, err)
+		}
+		manifest, _ := io.ReadAll(r)
+		for _, name := range test.skipped {
+			if !strings.Contains(string(manifest), name+": ") {
+				t.Errorf("%s = %q, expected it to list %s", ARCHIVE_ERRORS_FILE, manifest, name)
+			}
+		}
+
+		// The photo fits already, so it's copied as it is unless its metadata
+		// is stripped.
+		if a, _ := zr.Open("a.jpg"); nil != a {
+			if data, _ := io.ReadAll(a); test.strip == bytes.Equal(photo.Bytes(), data) {
+				t.Errorf("a.jpg with stripMetadata %t has %d bytes, original has %d bytes", test.strip, len(data), photo.Len())
+			}
+		}
+	}
+}
@@ -10,6 +10,14 @@ import (
 	"github.com/golang/glog"
 )
 
+type contextKey int
+
+const (
+	// subjectContextKey is the key of the authenticated subject in the
+	// context of requests.
+	subjectContextKey contextKey = iota
+)
+
 type authenticationMiddleware struct {
 	expectedAud string
 	expectedIss string
@@ -52,7 +60,8 @@ func (m authenticationMiddleware) Middleware(next http.Handler) http.Handler {
 
 		glog.V(2).Infof("Authenticated subject: %s", token.Subject)
 
-		next.ServeHTTP(w, r)
+		ctx = context.WithValue(r.Context(), subjectContextKey, token.Subject)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -74,3 +83,10 @@ func (m authenticationMiddleware) initialize() authenticationMiddleware {
 
 	return m
 }
+
+// getSubject gets the subject authenticated for the request with the given
+// context, if any.
+func getSubject(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectContextKey).(string)
+	return subject
+}
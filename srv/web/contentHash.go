@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	CONTENT_HASHES_PAGE_SIZE = 1000
+	// CONTENT_HASH_MAX_FILE_SIZE is the size of the largest file whose content
+	// hash is computed while indexing; larger files need the indexer to give
+	// their hash.
+	CONTENT_HASH_MAX_FILE_SIZE = 256 << 20
+)
+
+// isValidContentHash tells whether the given hash is a hex encoded SHA-256
+// hash.
+func isValidContentHash(hash string) bool {
+	if sha256.Size*2 != len(hash) {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return nil == err
+}
+
+// getContentHash gets the content hash of an item to index, as given by the
+// indexer or computed from the file under the photos directory. Items whose
+// file can't be read or is too large get no content hash.
+func (c *serverContext) getContentHash(item *models.ItemPayload) string {
+	if nil != item.ContentHash {
+		return strings.ToLower(*item.ContentHash)
+	} else if "" == c.photosRootDir {
+		return ""
+	}
+
+	f, err := os.Open(path.Join(c.photosRootDir, item.Path))
+	if nil != err {
+		glog.Warningf("Failed to open '%s' to compute its content hash: %v", item.Path, err)
+		return ""
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); nil != err {
+		glog.Warningf("Failed to stat '%s' to compute its content hash: %v", item.Path, err)
+		return ""
+	} else if info.Size() > CONTENT_HASH_MAX_FILE_SIZE {
+		glog.V(1).Infof("Not computing the content hash of '%s', which has %d bytes.", item.Path, info.Size())
+		return ""
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); nil != err {
+		glog.Warningf("Failed to read '%s' to compute its content hash: %v", item.Path, err)
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func getContentHashFromPayload(payload map[string]*pb.Value) string {
+	return payload[METADATA_CONTENT_HASH].GetStringValue()
+}
+
+// getSharedContentHashes gets the content hashes of the given results which
+// are shared with other photos, i.e. whose files have exact duplicates.
+func (c *serverContext) getSharedContentHashes(
+	ctx context.Context,
+	points []*pb.ScoredPoint,
+) (map[string]bool, error) {
+	var hashes []string
+	for _, point := range points {
+		if hash := getContentHashFromPayload(point.Payload); "" != hash {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	if len(hashes) < 1 {
+		return nil, nil
+	}
+
+	counts := make(map[string]int, len(hashes))
+	params := scrollParams{
+		PageSize: CONTENT_HASHES_PAGE_SIZE,
+		Filter: &pb.Filter{
+			Must: []*pb.Condition{{
+				ConditionOneOf: &pb.Condition_Field{
+					Field: &pb.FieldCondition{
+						Key: METADATA_CONTENT_HASH,
+						Match: &pb.Match{
+							MatchValue: &pb.Match_Keywords{
+								Keywords: &pb.RepeatedStrings{Strings: hashes},
+							},
+						},
+					},
+				},
+			}},
+		},
+		Fields: []string{METADATA_CONTENT_HASH},
+	}
+	for {
+		found, nextOffset, err := c.store.Scroll(ctx, params)
+		if nil != err {
+			return nil, err
+		}
+
+		for _, point := range found {
+			counts[getContentHashFromPayload(point.Payload)]++
+		}
+
+		if nil == nextOffset {
+			break
+		}
+		params.Offset = nextOffset
+	}
+
+	shared := make(map[string]bool)
+	for hash, count := range counts {
+		if count > 1 {
+			shared[hash] = true
+		}
+	}
+
+	return shared, nil
+}
+
+// listExactDuplicates lists the groups of photos with byte-identical files,
+// ordered by their content hash.
+func (c *serverContext) listExactDuplicates(
+	ctx context.Context,
+	limit uint,
+	cursor *string,
+) (*models.DuplicatesResponse, error) {
+	return c.listPayloadGroups(ctx, METADATA_CONTENT_HASH, limit, cursor)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestIsValidContentHash(t *testing.T) {
+	tests := []struct {
+		hash     string
+		expected bool
+	}{
+		{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", true},
+		{"9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08", true},
+		{"", false},
+		{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a0", false},
+		{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a0800", false},
+		{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a0g", false},
+		{"../../../../../../../../../../../../../../../../../../etc/passwd", false},
+	}
+
+	for _, test := range tests {
+		if actual := isValidContentHash(test.hash); test.expected != actual {
+			t.Errorf("isValidContentHash(%q) = %t, expected %t", test.hash, actual, test.expected)
+		}
+	}
+}
+
+func TestGetContentHash(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.jpg"), []byte("test"), 0o644); nil != err {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	c := &serverContext{photosRootDir: dir}
+	given := "ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789"
+	tests := []struct {
+		item     models.ItemPayload
+		expected string
+	}{
+		{models.ItemPayload{Path: "test.jpg"}, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+		{models.ItemPayload{Path: "test.jpg", ContentHash: &given}, "abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"},
+		{models.ItemPayload{Path: "missing.jpg"}, ""},
+	}
+
+	for _, test := range tests {
+		if actual := c.getContentHash(&test.item); test.expected != actual {
+			t.Errorf("getContentHash(%s) = %q, expected %q", test.item.Path, actual, test.expected)
+		}
+	}
+}
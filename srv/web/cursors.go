@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	// CURSOR_SLACK is the number of results before the position of a cursor
+	// that are fetched again for the next page, such that photos removed from
+	// the index in the meantime don't make the next page skip results. Since
+	// similarity searches can only be bounded by a minimum score, pages after
+	// the first are still fetched by offset; if more photos than this were
+	// removed, the cursor is rejected.
+	CURSOR_SLACK = 20
+	// CURSOR_MAX_DUPLICATES is the number of duplicate groups and content
+	// hashes of past results kept in cursors, which keeps cursors short when
+	// paging far. Duplicates of results further back may be returned again.
+	CURSOR_MAX_DUPLICATES = 100
+)
+
+// cursorSigningKey is the key used to sign cursors, so clients cannot tamper
+// with them.
+var cursorSigningKey []byte
+
+// initCursorSigningKey initializes the key used to sign cursors from the given
+// secret. Without a secret, a random key is used, which invalidates all cursors
+// when the server restarts.
+func initCursorSigningKey(secret string) {
+	if "" != secret {
+		key := sha256.Sum256([]byte(secret))
+		cursorSigningKey = key[:]
+		return
+	}
+
+	glog.Warning("No cursor secret configured; cursors will not survive restarts.")
+	cursorSigningKey = make([]byte, 32)
+	if _, err := rand.Read(cursorSigningKey); nil != err {
+		glog.Exitf("Failed to generate cursor signing key: %v", err)
+	}
+}
+
+// browsePosition is the position of a page when browsing photos by timestamp.
+type browsePosition struct {
+	// Timestamp is the timestamp of the last photo of the previous page.
+	Timestamp int64 `json:"t"`
+	// Ids holds the IDs of the photos with that timestamp that were already
+	// returned.
+	Ids []string `json:"i"`
+}
+
+// resultsPosition is the position of a page of similarity search results. It
+// also captures the query the results are for.
+type resultsPosition struct {
+	// VectorHash is the hash of the query vector.
+	VectorHash string `json:"v,omitempty"`
+	// Text is the query of a hybrid search, whose keywords are matched with
+	// the path.
+	Text string `json:"q,omitempty"`
+	// Positive holds the IDs of the photos similar photos are recommended for.
+	Positive []string `json:"p,omitempty"`
+	// Negative holds the IDs of the photos fewer photos are recommended like.
+	Negative []string `json:"m,omitempty"`
+	// PositiveVectors and NegativeVectors hold the hashes of the vectors of
+	// the texts describing photos to recommend more and fewer photos like.
+	PositiveVectors []string `json:"pv,omitempty"`
+	NegativeVectors []string `json:"nv,omitempty"`
+	// Strategy is the recommendation strategy.
+	Strategy string              `json:"r,omitempty"`
+	Filter   *models.PhotoFilter `json:"f,omitempty"`
+	// Collapse is set if only the first result of each group of near-duplicates
+	// is returned, and Groups holds the groups of the most recent results
+	// returned so far.
+	Collapse bool     `json:"c,omitempty"`
+	Groups   []string `json:"g,omitempty"`
+	// KeepDuplicates is set if results with the same content as a result
+	// returned before are not skipped. Otherwise, Hashes holds the content
+	// hashes of the most recent results returned so far which have exact
+	// duplicates.
+	KeepDuplicates bool     `json:"k,omitempty"`
+	Hashes         []string `json:"h,omitempty"`
+
+	// Count is the number of results up to and including the last result of
+	// the previous page.
+	Count uint64 `json:"n"`
+	// Score is the score of the last result of the previous page.
+	Score float32 `json:"s"`
+	// Ids holds the IDs of the results with that score that were already
+	// returned.
+	Ids []string `json:"i"`
+}
+
+// makeBrowseCursor makes the cursor for the page following the given (non
+// empty) page of points, which was fetched using the given cursor.
+func makeBrowseCursor(points []*pb.RetrievedPoint, cursor *string) *string {
+	last := getTimestampFromPayload(points[len(points)-1].Payload)
+	position := browsePosition{Timestamp: *last}
+
+	if nil != cursor {
+		// If the page is full of photos with the same timestamp as the previous
+		// page, keep skipping those of the previous pages too.
+		previous, err := parseBrowseCursor(*cursor)
+		if nil == err && previous.Timestamp == position.Timestamp {
+			position.Ids = previous.Ids
+		}
+	}
+
+	for _, p := range points {
+		if *getTimestampFromPayload(p.Payload) == position.Timestamp {
+			position.Ids = append(position.Ids, p.Id.GetUuid())
+		}
+	}
+
+	return signCursor(position)
+}
+
+func parseBrowseCursor(cursor string) (*browsePosition, error) {
+	position := &browsePosition{}
+	if err := verifyCursor(cursor, position); nil != err {
+		return nil, err
+	}
+
+	return position, nil
+}
+
+// isSeen checks if the given result was returned on a previous page.
+func (p *resultsPosition) isSeen(point *pb.ScoredPoint) bool {
+	return point.Score > p.Score ||
+		(point.Score == p.Score && slices.Contains(p.Ids, point.Id.GetUuid()))
+}
+
+// isCollapsed checks if the given result is a near-duplicate or an exact
+// duplicate of a result that was returned before, and should therefore be
+// skipped.
+func (p *resultsPosition) isCollapsed(point *pb.ScoredPoint) bool {
+	group := getDupGroupFromPayload(point.Payload)
+	hash := getContentHashFromPayload(point.Payload)
+
+	return (p.Collapse && "" != group && slices.Contains(p.Groups, group)) ||
+		(!p.KeepDuplicates && "" != hash && slices.Contains(p.Hashes, hash))
+}
+
+// skipsDuplicates checks if any results are skipped as duplicates of results
+// returned before.
+func (p *resultsPosition) skipsDuplicates() bool {
+	return p.Collapse || !p.KeepDuplicates
+}
+
+// next gets the position following the given result, which is at the given
+// (zero-based) index in the results.
+func (p resultsPosition) next(point *pb.ScoredPoint, index uint64) resultsPosition {
+	next := resultsPosition{
+		VectorHash:      p.VectorHash,
+		Text:            p.Text,
+		Positive:        p.Positive,
+		Negative:        p.Negative,
+		PositiveVectors: p.PositiveVectors,
+		NegativeVectors: p.NegativeVectors,
+		Strategy:        p.Strategy,
+		Filter:          p.Filter,
+		Collapse:        p.Collapse,
+		Groups:          p.Groups,
+		KeepDuplicates:  p.KeepDuplicates,
+		Hashes:          p.Hashes,
+		Count:           index + 1,
+		Score:           point.Score,
+	}
+
+	if p.Score == point.Score {
+		next.Ids = slices.Clone(p.Ids)
+	}
+	if group := getDupGroupFromPayload(point.Payload); p.Collapse && "" != group &&
+		!slices.Contains(p.Groups, group) {
+		next.Groups = appendRecent(p.Groups, group)
+	}
+	next.Ids = append(next.Ids, point.Id.GetUuid())
+
+	return next
+}
+
+// appendRecent appends the value to a copy of the given values, keeping only
+// the last CURSOR_MAX_DUPLICATES values.
+func appendRecent(values []string, value string) []string {
+	values = append(slices.Clone(values), value)
+	if len(values) > CURSOR_MAX_DUPLICATES {
+		values = values[len(values)-CURSOR_MAX_DUPLICATES:]
+	}
+
+	return values
+}
+
+func parseResultsCursor(cursor string) (*resultsPosition, error) {
+	position := &resultsPosition{}
+	if err := verifyCursor(cursor, position); nil != err {
+		return nil, err
+	}
+
+	return position, nil
+}
+
+// signCursor encodes the given position as a signed cursor.
+func signCursor(position any) *string {
+	return signWithKey(cursorSigningKey, position)
+}
+
+// verifyCursor verifies the signature of the given cursor and decodes the
+// position it holds.
+func verifyCursor(cursor string, position any) error {
+	return verifyWithKey(cursorSigningKey, cursor, position)
+}
+
+// signWithKey encodes the given value, signed with the given key.
+func signWithKey(key []byte, value any) *string {
+	data, err := json.Marshal(value)
+	if nil != err {
+		glog.Errorf("Failed to encode cursor: %v", err)
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	cursor := base64.RawURLEncoding.EncodeToString(data) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return &cursor
+}
+
+// verifyWithKey verifies the signature of the given cursor with the given key
+// and decodes the value it holds.
+func verifyWithKey(key []byte, cursor string, value any) error {
+	encodedData, encodedSignature, found := strings.Cut(cursor, ".")
+	if !found {
+		return InvalidCursor
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encodedData)
+	if nil != err {
+		return InvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if nil != err {
+		return InvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		glog.V(1).Infof("Invalid signature for cursor '%s'.", cursor)
+		return InvalidCursor
+	}
+
+	if err := json.Unmarshal(data, value); nil != err {
+		glog.V(1).Infof("Failed to parse cursor '%s': %v", cursor, err)
+		return InvalidCursor
+	}
+
+	return nil
+}
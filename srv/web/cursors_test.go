@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestSearchPageCursor(t *testing.T) {
+	initCursorSigningKey("test")
+
+	// The points' similarity to the query decreases with their index.
+	query := testVector(0, 1)
+	newContext := func(t *testing.T) (*serverContext, []string) {
+		store := newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db"))
+		ids := make([]string, 40)
+		points := make([]*pb.PointStruct, len(ids))
+		for i := range ids {
+			ids[i] = testPointId(i)
+			v := testVector(0, 1)
+			v[1] = float32(i) / 10
+			points[i] = testPoint(ids[i], v, map[string]any{"path": ids[i]})
+		}
+		if err := store.Upsert(points); nil != err {
+			t.Fatalf("Upsert() failed: %v", err)
+		}
+
+		return &serverContext{store: store}, ids
+	}
+
+	getPage := func(c *serverContext, cursor *string) (*models.PhotoResultsResponse, error) {
+		var position *resultsPosition
+		if nil != cursor {
+			var err error
+			if position, err = parseResultsCursor(*cursor); nil != err {
+				return nil, err
+			}
+		}
+
+		return c.searchPage(context.Background(), 10, nil, position, nil,
+			resultsPosition{KeepDuplicates: true},
+			func(params searchParams) ([]*pb.ScoredPoint, error) {
+				return c.store.Search(query, params)
+			})
+	}
+
+	pageIds := func(page *models.PhotoResultsResponse) []string {
+		ids := make([]string, len(page.Items))
+		for i, item := range page.Items {
+			ids[i] = item.Id
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		// removed holds the range of points removed after the third page.
+		removed [2]int
+		valid   bool
+	}{
+		{"nothing removed", [2]int{0, 0}, true},
+		{"some removed", [2]int{5, 20}, true},
+		{"too many removed", [2]int{0, 25}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, ids := newContext(t)
+
+			var cursor *string
+			for i := range 3 {
+				page, err := getPage(c, cursor)
+				if nil != err {
+					t.Fatalf("getting page %d failed: %v", i, err)
+				}
+				if actual := pageIds(page); !slices.Equal(ids[i*10:(i+1)*10], actual) {
+					t.Fatalf("page %d = %v, expected %v", i, actual, ids[i*10:(i+1)*10])
+				}
+				cursor = page.Cursor
+			}
+
+			if err := c.store.Delete(ids[test.removed[0]:test.removed[1]]); nil != err {
+				t.Fatalf("Delete() failed: %v", err)
+			}
+
+			page, err := getPage(c, cursor)
+			if !test.valid {
+				if InvalidCursor != err {
+					t.Errorf("getting last page returned %v, expected %v", err, InvalidCursor)
+				}
+				return
+			} else if nil != err {
+				t.Fatalf("getting last page failed: %v", err)
+			}
+
+			if actual := pageIds(page); !slices.Equal(ids[30:], actual) || nil != page.Cursor {
+				t.Errorf("last page = %v, %v, expected %v, nil", actual, page.Cursor, ids[30:])
+			}
+		})
+	}
+}
+
+func TestResultsPositionKeepsRecentGroups(t *testing.T) {
+	position := resultsPosition{Collapse: true}
+	for i := range CURSOR_MAX_DUPLICATES + 10 {
+		point := &pb.ScoredPoint{
+			Id:      makePointId(testPointId(i)),
+			Payload: map[string]*pb.Value{METADATA_DUP_GROUP: pb.NewValueString(testPointId(i))},
+		}
+		position = position.next(point, uint64(i))
+	}
+
+	if CURSOR_MAX_DUPLICATES != len(position.Groups) {
+		t.Fatalf("position has %d groups, expected %d", len(position.Groups), CURSOR_MAX_DUPLICATES)
+	}
+	if testPointId(10) != position.Groups[0] ||
+		testPointId(CURSOR_MAX_DUPLICATES+9) != position.Groups[CURSOR_MAX_DUPLICATES-1] {
+		t.Errorf("position groups %v..%v are not the most recent ones",
+			position.Groups[0], position.Groups[CURSOR_MAX_DUPLICATES-1])
+	}
+
+	hashes := []string{}
+	for i := range CURSOR_MAX_DUPLICATES + 1 {
+		hashes = appendRecent(hashes, testPointId(i))
+	}
+	if CURSOR_MAX_DUPLICATES != len(hashes) || testPointId(1) != hashes[0] {
+		t.Errorf("appendRecent() kept %d values starting with %s, expected %d starting with %s",
+			len(hashes), hashes[0], CURSOR_MAX_DUPLICATES, testPointId(1))
+	}
+}
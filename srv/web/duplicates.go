@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	DUPLICATES_PAGE_SIZE = 1000
+	// DUPLICATES_BATCH_SIZE is the number of photos whose near-duplicates are
+	// looked up in a single request.
+	DUPLICATES_BATCH_SIZE = 100
+	// DUPLICATES_NEIGHBORS is the maximum number of near-duplicates looked up
+	// for each photo.
+	DUPLICATES_NEIGHBORS = 20
+)
+
+// duplicatesState tracks modifications of the collection, so near-duplicates
+// are only detected again after photos were added or removed.
+type duplicatesState struct {
+	mutex      sync.Mutex
+	generation uint64
+}
+
+// duplicatesPosition is the position of a page of duplicate groups.
+type duplicatesPosition struct {
+	// Group is the ID of the last group of the previous page.
+	Group string `json:"g"`
+}
+
+func (d *duplicatesState) get() uint64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.generation
+}
+
+func (d *duplicatesState) invalidate() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.generation++
+}
+
+// runDuplicateDetection detects near-duplicates right away, and then again at
+// the given interval whenever the collection was modified in the meantime.
+func (c *serverContext) runDuplicateDetection(interval time.Duration, threshold float32) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	detected := false
+	var detectedGeneration uint64
+	for {
+		generation := c.duplicates.get()
+		if !detected || generation != detectedGeneration {
+			if err := c.detectDuplicates(context.Background(), threshold); nil != err {
+				glog.Errorf("Failed to detect near-duplicates: %v", err)
+			} else {
+				detected = true
+				detectedGeneration = generation
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// detectDuplicates groups photos whose cosine similarity is at least the given
+// threshold, directly or through other photos, and stores the ID of their
+// group in the dup_group payload field. The ID of a group is the smallest ID
+// of the photos in it.
+func (c *serverContext) detectDuplicates(ctx context.Context, threshold float32) error {
+	start := time.Now()
+
+	// Only the photos in groups, before and after the detection, are kept.
+	current := make(map[string]string)
+	groups := newDisjointSets()
+	count := 0
+	params := scrollParams{
+		PageSize: DUPLICATES_PAGE_SIZE,
+		Fields:   []string{METADATA_DUP_GROUP},
+	}
+	for {
+		points, nextOffset, err := c.store.Scroll(ctx, params)
+		if nil != err {
+			return err
+		}
+
+		ids := make([]string, len(points))
+		for i, point := range points {
+			ids[i] = point.Id.GetUuid()
+			if group := getDupGroupFromPayload(point.Payload); "" != group {
+				current[ids[i]] = group
+			}
+		}
+		count += len(ids)
+
+		for batch := range slices.Chunk(ids, DUPLICATES_BATCH_SIZE) {
+			similar, err := c.findSimilar(batch, threshold)
+			if nil != err {
+				return err
+			}
+
+			for i, id := range batch {
+				for _, point := range similar[i] {
+					groups.union(id, point.Id.GetUuid())
+				}
+			}
+		}
+
+		if nil == nextOffset {
+			break
+		}
+		params.Offset = nextOffset
+	}
+
+	// Only update the photos whose group changed.
+	updates := make(map[string][]string)
+	var removed []string
+	for _, id := range slices.Concat(slices.Collect(maps.Keys(current)), groups.members()) {
+		group := current[id]
+		newGroup := groups.find(id)
+		if groups.size(newGroup) < 2 {
+			newGroup = ""
+		}
+
+		if newGroup == group || slices.Contains(updates[newGroup], id) {
+			continue
+		} else if "" == newGroup {
+			removed = append(removed, id)
+		} else {
+			updates[newGroup] = append(updates[newGroup], id)
+		}
+	}
+
+	for group, ids := range updates {
+		err := c.store.SetPayload(ids, map[string]*pb.Value{
+			METADATA_DUP_GROUP: {Kind: &pb.Value_StringValue{StringValue: group}},
+		})
+		if nil != err {
+			return err
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := c.store.DeletePayload(removed, []string{METADATA_DUP_GROUP}); nil != err {
+			return err
+		}
+	}
+
+	glog.Infof("Detected near-duplicates among %d photos in %v; updated %d group(s), removed %d photo(s) from groups.",
+		count, time.Since(start), len(updates), len(removed))
+
+	return nil
+}
+
+// findSimilar finds the photos at least as similar as the threshold to each of
+// the photos with the given IDs, in a single batch. If some of the photos were
+// removed in the meantime, they are looked up one by one instead.
+func (c *serverContext) findSimilar(ids []string, threshold float32) ([][]*pb.ScoredPoint, error) {
+	params := searchParams{Limit: DUPLICATES_NEIGHBORS, ScoreThreshold: &threshold}
+	examples := make([]recommendExamples, len(ids))
+	for i, id := range ids {
+		examples[i] = recommendExamples{Positive: []string{id}}
+	}
+
+	similar, err := c.store.RecommendBatch(examples, params)
+	if !errors.Is(err, PhotoNotFound) {
+		return similar, err
+	}
+
+	similar = make([][]*pb.ScoredPoint, len(ids))
+	for i, e := range examples {
+		similar[i], err = c.store.Recommend(e, params)
+		if nil != err && !errors.Is(err, PhotoNotFound) {
+			return nil, err
+		}
+	}
+
+	return similar, nil
+}
+
+// listDuplicates lists the groups of near-duplicates, ordered by their ID.
+func (c *serverContext) listDuplicates(
+	ctx context.Context,
+	limit uint,
+	cursor *string,
+) (*models.DuplicatesResponse, error) {
+	return c.listPayloadGroups(ctx, METADATA_DUP_GROUP, limit, cursor)
+}
+
+// listPayloadGroups lists the groups of photos sharing the value of the given
+// payload field, ordered by that value. Photos without the field, or not
+// sharing its value with other photos, are not included.
+func (c *serverContext) listPayloadGroups(
+	ctx context.Context,
+	key string,
+	limit uint,
+	cursor *string,
+) (*models.DuplicatesResponse, error) {
+	if limit < 1 {
+		return nil, InvalidLimit
+	}
+
+	var position duplicatesPosition
+	if nil != cursor {
+		if err := verifyCursor(*cursor, &position); nil != err {
+			return nil, err
+		}
+	}
+
+	members := make(map[string][]*pb.RetrievedPoint)
+	params := scrollParams{
+		PageSize: DUPLICATES_PAGE_SIZE,
+		Filter: &pb.Filter{
+			MustNot: []*pb.Condition{{
+				ConditionOneOf: &pb.Condition_IsEmpty{
+					IsEmpty: &pb.IsEmptyCondition{Key: key},
+				},
+			}},
+		},
+		Fields: []string{METADATA_PATH, METADATA_TIMESTAMP, METADATA_MEDIA_TYPE, key},
+	}
+	for {
+		points, nextOffset, err := c.store.Scroll(ctx, params)
+		if nil != err {
+			return nil, err
+		}
+
+		for _, point := range points {
+			group := point.Payload[key].GetStringValue()
+			if group > position.Group {
+				members[group] = append(members[group], point)
+			}
+		}
+
+		if nil == nextOffset {
+			break
+		}
+		params.Offset = nextOffset
+	}
+
+	groupIds := make([]string, 0, len(members))
+	for group, points := range members {
+		if len(points) > 1 {
+			groupIds = append(groupIds, group)
+		}
+	}
+	slices.Sort(groupIds)
+
+	result := &models.DuplicatesResponse{Groups: []*models.DuplicateGroup{}}
+	for i, group := range groupIds {
+		if i > 0 && i == int(limit) {
+			result.Cursor = signCursor(duplicatesPosition{Group: groupIds[i-1]})
+			break
+		}
+
+		points := members[group]
+		sort.Slice(points, func(i, j int) bool {
+			return strings.Compare(points[i].Id.GetUuid(), points[j].Id.GetUuid()) < 0
+		})
+		result.Groups = append(result.Groups, &models.DuplicateGroup{
+			Id:    group,
+			Items: makeBrowseResultsResponse(points, nil).Items,
+		})
+	}
+
+	return result, nil
+}
+
+func getDupGroupFromPayload(payload map[string]*pb.Value) string {
+	return payload[METADATA_DUP_GROUP].GetStringValue()
+}
+
+// disjointSets is a union-find structure over string IDs, whose sets are
+// represented by their smallest ID.
+type disjointSets struct {
+	parents map[string]string
+	sizes   map[string]int
+}
+
+func newDisjointSets() *disjointSets {
+	return &disjointSets{
+		parents: make(map[string]string),
+		sizes:   make(map[string]int),
+	}
+}
+
+func (d *disjointSets) find(id string) string {
+	parent, found := d.parents[id]
+	if !found {
+		return id
+	}
+
+	root := d.find(parent)
+	d.parents[id] = root
+
+	return root
+}
+
+func (d *disjointSets) union(a, b string) {
+	rootA, rootB := d.find(a), d.find(b)
+	if rootA == rootB {
+		return
+	} else if rootB < rootA {
+		rootA, rootB = rootB, rootA
+	}
+
+	d.parents[rootB] = rootA
+	d.sizes[rootA] = d.size(rootA) + d.size(rootB)
+	delete(d.sizes, rootB)
+}
+
+// members gets the IDs which are in a set with other IDs.
+func (d *disjointSets) members() []string {
+	// The roots of sets with other IDs are the only IDs with sizes.
+	return slices.Concat(slices.Collect(maps.Keys(d.parents)), slices.Collect(maps.Keys(d.sizes)))
+}
+
+// size gets the size of the set with the given root.
+func (d *disjointSets) size(root string) int {
+	if size, found := d.sizes[root]; found {
+		return size
+	}
+
+	return 1
+}
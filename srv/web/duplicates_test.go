@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+func TestDetectDuplicates(t *testing.T) {
+	store := newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db"))
+	err := store.Upsert([]*pb.PointStruct{
+		testPoint(testPointId(1), testVector(0, 0.9), nil),
+		testPoint(testPointId(2), testVector(0, 0.95), nil),
+		testPoint(testPointId(3), testVector(1, 0.9), nil),
+		testPoint(testPointId(4), testVector(2, 0.9), map[string]any{METADATA_DUP_GROUP: "stale"}),
+	})
+	if nil != err {
+		t.Fatalf("Upsert() failed: %v", err)
+	}
+
+	c := &serverContext{store: store}
+	if err := c.detectDuplicates(context.Background(), 0.99); nil != err {
+		t.Fatalf("detectDuplicates() failed: %v", err)
+	}
+
+	expected := map[string]string{
+		testPointId(1): testPointId(1),
+		testPointId(2): testPointId(1),
+		testPointId(3): "",
+		testPointId(4): "",
+	}
+	for id, group := range expected {
+		payload, err := store.GetPayload(id)
+		if nil != err {
+			t.Fatalf("GetPayload(%s) failed: %v", id, err)
+		}
+		if actual := getDupGroupFromPayload(payload); group != actual {
+			t.Errorf("Group of %s = %q, expected %q", id, actual, group)
+		}
+	}
+}
+
+func TestDisjointSets(t *testing.T) {
+	d := newDisjointSets()
+	d.union("c", "d")
+	d.union("b", "c")
+	d.union("x", "y")
+
+	for _, id := range []string{"b", "c", "d"} {
+		if root := d.find(id); "b" != root {
+			t.Errorf("find(%s) = %s, expected b", id, root)
+		}
+	}
+	if size := d.size("b"); 3 != size {
+		t.Errorf("size(b) = %d, expected 3", size)
+	}
+	if size := d.size(d.find("y")); 2 != size {
+		t.Errorf("size(y) = %d, expected 2", size)
+	}
+	if members := d.members(); 5 != len(members) {
+		t.Errorf("members() = %v, expected 5 members", members)
+	}
+}
+
+func TestListDuplicatesLimit(t *testing.T) {
+	initCursorSigningKey("test")
+
+	store := newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db"))
+	err := store.Upsert([]*pb.PointStruct{
+		testPoint(testPointId(1), testVector(0, 1), map[string]any{METADATA_DUP_GROUP: "a", METADATA_CONTENT_HASH: "x"}),
+		testPoint(testPointId(2), testVector(0, 1), map[string]any{METADATA_DUP_GROUP: "a", METADATA_CONTENT_HASH: "x"}),
+		testPoint(testPointId(3), testVector(1, 1), map[string]any{METADATA_DUP_GROUP: "b", METADATA_CONTENT_HASH: "y"}),
+		testPoint(testPointId(4), testVector(1, 1), map[string]any{METADATA_DUP_GROUP: "b", METADATA_CONTENT_HASH: "y"}),
+	})
+	if nil != err {
+		t.Fatalf("Upsert() failed: %v", err)
+	}
+	c := &serverContext{store: store}
+
+	if _, err := c.listDuplicates(context.Background(), 0, nil); InvalidLimit != err {
+		t.Errorf("listDuplicates() with limit 0 returned %v, expected %v", err, InvalidLimit)
+	}
+	if _, err := c.listExactDuplicates(context.Background(), 0, nil); InvalidLimit != err {
+		t.Errorf("listExactDuplicates() with limit 0 returned %v, expected %v", err, InvalidLimit)
+	}
+
+	res, err := c.listDuplicates(context.Background(), 1, nil)
+	if nil != err {
+		t.Fatalf("listDuplicates() failed: %v", err)
+	} else if 1 != len(res.Groups) || "a" != res.Groups[0].Id || nil == res.Cursor {
+		t.Errorf("listDuplicates() with limit 1 = %v, expected group a and a cursor", res)
+	}
+
+	handlers := map[string]http.HandlerFunc{
+		"/api/v1/duplicates": publicServerContext{serverContext: c}.handleV1GetDuplicates,
+		"/v1/duplicates":     internalServerContext{serverContext: c}.handleV1GetExactDuplicates,
+	}
+	for path, handler := range handlers {
+		for _, limit := range []string{"0", "-1", "x"} {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest("GET", path+"?limit="+limit, nil))
+			if 400 != w.Code {
+				t.Errorf("GET %s?limit=%s responded with %d, expected 400", path, limit, w.Code)
+			}
+		}
+	}
+}
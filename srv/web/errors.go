@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
 )
 
 var (
@@ -15,12 +16,135 @@ var (
 		code:        "vector_database_unavailable",
 		message:     "vector database unavailable",
 		recoverable: true})
+	PhotoNotFound = error(&photoSearchError{
+		code:        "photo_not_found",
+		message:     "photo not found",
+		recoverable: false,
+		status:      404})
+	InvalidCursor = error(&photoSearchError{
+		code:        "invalid_cursor",
+		message:     "invalid cursor",
+		recoverable: false,
+		status:      400})
+	InvalidQuery = error(&photoSearchError{
+		code:        "invalid_query",
+		message:     "query must have at least one term which is not negated, and weights must be positive",
+		recoverable: false,
+		status:      400})
+	InvalidRecommendExamples = error(&photoSearchError{
+		code:        "invalid_recommend_examples",
+		message:     "at least one positive example is needed, and strategy must be either 'averageVector' or 'bestScore'",
+		recoverable: false,
+		status:      400})
+	InvalidImageUpload = error(&photoSearchError{
+		code:        "invalid_image_upload",
+		message:     "an image of at most " + strconv.Itoa(MAX_IMAGE_UPLOAD_SIZE>>20) + " MiB must be uploaded in the 'image' part of a multipart form",
+		recoverable: false,
+		status:      400})
+	ImageSearchNotConfigured = error(&photoSearchError{
+		code:        "image_search_not_configured",
+		message:     "search by image is not configured",
+		recoverable: false,
+		status:      501})
+	InvalidSearchMode = error(&photoSearchError{
+		code:        "invalid_search_mode",
+		message:     "search mode must be either 'vector' or 'hybrid', and hybrid search does not support a minimum score",
+		recoverable: false,
+		status:      400})
+	InvalidBrowseOrder = error(&photoSearchError{
+		code:        "invalid_browse_order",
+		message:     "order must be either 'asc' or 'desc'",
+		recoverable: false,
+		status:      400})
+	InvalidLimit = error(&photoSearchError{
+		code:        "invalid_limit",
+		message:     "limit must be a positive integer",
+		recoverable: false,
+		status:      400})
+	InvalidGeoQuery = error(&photoSearchError{
+		code:        "invalid_geo_query",
+		message:     "bbox must be 'west,south,east,north' in degrees, and zoom an integer between 0 and " + strconv.Itoa(GEO_MAX_ZOOM),
+		recoverable: false,
+		status:      400})
+	AlbumNotFound = error(&photoSearchError{
+		code:        "album_not_found",
+		message:     "album not found",
+		recoverable: false,
+		status:      404})
+	InvalidAlbum = error(&photoSearchError{
+		code:        "invalid_album",
+		message:     "album name must not be empty",
+		recoverable: false,
+		status:      400})
+	InvalidRating = error(&photoSearchError{
+		code:        "invalid_rating",
+		message:     "rating must be an integer between " + strconv.Itoa(MIN_RATING) + " and " + strconv.Itoa(MAX_RATING),
+		recoverable: false,
+		status:      400})
+	NotAuthenticated = error(&photoSearchError{
+		code:        "not_authenticated",
+		message:     "not authenticated",
+		recoverable: false,
+		status:      401})
+	InvalidTags = error(&photoSearchError{
+		code:        "invalid_tags",
+		message:     "tags must not be empty nor longer than " + strconv.Itoa(MAX_TAG_LENGTH) + " characters, and tags mode must be either 'any' or 'all'",
+		recoverable: false,
+		status:      400})
+	ShareNotFound = error(&photoSearchError{
+		code:        "share_not_found",
+		message:     "share not found, expired or revoked",
+		recoverable: false,
+		status:      404})
+	InvalidShare = error(&photoSearchError{
+		code:        "invalid_share",
+		message:     "either an album or photos must be shared, for at most a year",
+		recoverable: false,
+		status:      400})
+	SharePasswordRequired = error(&photoSearchError{
+		code:        "share_password_required",
+		message:     "share is protected by a password",
+		recoverable: false,
+		status:      401})
+	WrongSharePassword = error(&photoSearchError{
+		code:        "wrong_share_password",
+		message:     "wrong password",
+		recoverable: false,
+		status:      401})
+	TooManyUnlockAttempts = error(&photoSearchError{
+		code:        "too_many_unlock_attempts",
+		message:     "too many wrong passwords, try again later",
+		recoverable: false,
+		status:      429})
+	InvalidArchiveRequest = error(&photoSearchError{
+		code:        "invalid_archive_request",
+		message:     "between 1 and " + strconv.Itoa(MAX_ARCHIVE_PHOTOS) + " photos must be requested, and the maximum dimension must be between 1 and " + strconv.Itoa(MAX_ARCHIVE_DIMENSION),
+		recoverable: false,
+		status:      400})
+	InvalidContentHash = error(&photoSearchError{
+		code:        "invalid_content_hash",
+		message:     "content hash must be a hex encoded SHA-256 hash",
+		recoverable: false,
+		status:      400})
+	InvalidMediaType = error(&photoSearchError{
+		code:        "invalid_media_type",
+		message:     "media type must be either 'photo' or 'video'",
+		recoverable: false,
+		status:      400})
+	InvalidThumbnailWidth = error(&photoSearchError{
+		code:        "invalid_thumbnail_width",
+		message:     "thumbnail width must be an integer between 1 and " + strconv.Itoa(MAX_THUMBNAIL_WIDTH),
+		recoverable: false,
+		status:      400})
 )
 
 type photoSearchError struct {
 	code        string
 	message     string
 	recoverable bool
+	// status is the HTTP status code to respond with; if not set, it is
+	// derived from recoverable.
+	status int
 }
 
 func (e *photoSearchError) Error() string {
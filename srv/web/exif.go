@@ -0,0 +1,226 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	EXIF_GPS_LATITUDE       = "GPSLatitude"
+	EXIF_GPS_LATITUDE_REF   = "GPSLatitudeRef"
+	EXIF_GPS_LONGITUDE      = "GPSLongitude"
+	EXIF_GPS_LONGITUDE_REF  = "GPSLongitudeRef"
+	EXIF_GPS_ALTITUDE       = "GPSAltitude"
+	EXIF_GPS_ALTITUDE_REF   = "GPSAltitudeRef"
+	EXIF_RATIONAL_PRECISION = 1e4
+)
+
+// exifFractionTags holds the rational EXIF tags which are best rendered as a
+// fraction, like exposure times of "1/250".
+var exifFractionTags = map[string]bool{
+	EXIF_EXPOSURE_TIME: true,
+}
+
+// exifTagPolicy decides which EXIF tags are exposed to clients.
+type exifTagPolicy struct {
+	// allow holds the tags to expose; all tags are exposed if empty.
+	allow map[string]bool
+	// deny holds the tags never to expose.
+	deny map[string]bool
+}
+
+// newExifTagPolicy creates a policy from the given comma-separated lists of
+// allowed and denied tags.
+func newExifTagPolicy(allow, deny string) *exifTagPolicy {
+	return &exifTagPolicy{
+		allow: parseExifTagList(allow),
+		deny:  parseExifTagList(deny),
+	}
+}
+
+func parseExifTagList(tags string) map[string]bool {
+	result := make(map[string]bool)
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if "" != tag {
+			result[tag] = true
+		}
+	}
+
+	return result
+}
+
+// allows checks if the given tag may be exposed.
+func (p *exifTagPolicy) allows(tag string) bool {
+	if p.deny[tag] {
+		return false
+	}
+
+	return len(p.allow) < 1 || p.allow[tag]
+}
+
+// allowsLocation checks if the GPS location may be exposed, which requires
+// both the latitude and the longitude tags to be allowed.
+func (p *exifTagPolicy) allowsLocation() bool {
+	return p.allows(EXIF_GPS_LATITUDE) && p.allows(EXIF_GPS_LONGITUDE)
+}
+
+// renderExifValue converts an EXIF tag value from the payload to its plain Go
+// representation, rendering rationals as numbers, or as fractions for the tags
+// where that's more common.
+func renderExifValue(tag string, v *pb.Value) any {
+	if num, den, ok := getRationalFromValue(v); ok {
+		return renderRational(tag, num, den)
+	}
+
+	if list := v.GetListValue(); nil != list {
+		values := make([]any, len(list.GetValues()))
+		for i, value := range list.GetValues() {
+			values[i] = renderExifValue(tag, value)
+		}
+		return values
+	}
+
+	return payloadValueToAny(v)
+}
+
+func renderRational(tag string, num, den int64) any {
+	if 0 == den {
+		return nil
+	} else if 0 == num%den {
+		return num / den
+	}
+
+	if exifFractionTags[tag] {
+		if divisor := gcd(num, den); divisor > 1 {
+			num, den = num/divisor, den/divisor
+		}
+
+		if 1 == num {
+			return strconv.FormatInt(num, 10) + "/" + strconv.FormatInt(den, 10)
+		}
+	}
+
+	return math.Round(float64(num)/float64(den)*EXIF_RATIONAL_PRECISION) / EXIF_RATIONAL_PRECISION
+}
+
+// getRationalFromValue gets the numerator and denominator of a rational value,
+// represented as a list of two integers.
+func getRationalFromValue(v *pb.Value) (int64, int64, bool) {
+	values := v.GetListValue().GetValues()
+	if len(values) != 2 {
+		return 0, 0, false
+	}
+
+	num, numOk := values[0].Kind.(*pb.Value_IntegerValue)
+	den, denOk := values[1].Kind.(*pb.Value_IntegerValue)
+	if !numOk || !denOk {
+		return 0, 0, false
+	}
+
+	return num.IntegerValue, den.IntegerValue, true
+}
+
+// getRationalOrNumber gets the value of either a rational or a plain number.
+func getRationalOrNumber(v *pb.Value) (float64, bool) {
+	if num, den, ok := getRationalFromValue(v); ok {
+		if 0 == den {
+			return 0, false
+		}
+		return float64(num) / float64(den), true
+	}
+
+	return getNumericValue(v)
+}
+
+// getGpsCoordinate gets a GPS coordinate in decimal degrees from a value which
+// is either in decimal degrees already, or a list of rationals for degrees,
+// minutes and seconds.
+func getGpsCoordinate(v *pb.Value) (float64, bool) {
+	if f, ok := getRationalOrNumber(v); ok {
+		return f, true
+	}
+
+	values := v.GetListValue().GetValues()
+	if len(values) < 1 || len(values) > 3 {
+		return 0, false
+	}
+
+	result := 0.0
+	for i, value := range values {
+		f, ok := getRationalOrNumber(value)
+		if !ok {
+			return 0, false
+		}
+		result += f / math.Pow(60, float64(i))
+	}
+
+	return result, true
+}
+
+// getLocationFromPayload gets the GPS location from the EXIF tags in the
+// payload, if any.
+func getLocationFromPayload(payload map[string]*pb.Value) *models.Location {
+	latField := getExifFieldFromPayload(payload, EXIF_GPS_LATITUDE)
+	lonField := getExifFieldFromPayload(payload, EXIF_GPS_LONGITUDE)
+	if nil == latField || nil == lonField {
+		return nil
+	}
+
+	lat, latOk := getGpsCoordinate(latField)
+	lon, lonOk := getGpsCoordinate(lonField)
+	if !latOk || !lonOk {
+		return nil
+	}
+
+	if ref := getExifFieldFromPayload(payload, EXIF_GPS_LATITUDE_REF); nil != ref &&
+		strings.EqualFold(strings.TrimSpace(ref.GetStringValue()), "S") {
+		lat = -lat
+	}
+	if ref := getExifFieldFromPayload(payload, EXIF_GPS_LONGITUDE_REF); nil != ref &&
+		strings.EqualFold(strings.TrimSpace(ref.GetStringValue()), "W") {
+		lon = -lon
+	}
+
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil
+	}
+
+	location := &models.Location{
+		Latitude:  lat,
+		Longitude: lon,
+	}
+
+	if field := getExifFieldFromPayload(payload, EXIF_GPS_ALTITUDE); nil != field {
+		if alt, ok := getRationalOrNumber(field); ok {
+			// An altitude reference of 1 means below sea level.
+			if ref := getExifFieldFromPayload(payload, EXIF_GPS_ALTITUDE_REF); nil != ref {
+				if f, ok := getNumericValue(ref); ok && 1 == f {
+					alt = -alt
+				}
+			}
+			location.Altitude = &alt
+		}
+	}
+
+	return location
+}
+
+func gcd(a, b int64) int64 {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+
+	for 0 != b {
+		a, b = b, a%b
+	}
+
+	return a
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	FACETS_PAGE_SIZE = 1000
+)
+
+// facetsCache caches the facets of the collection until the collection is
+// modified.
+type facetsCache struct {
+	mutex      sync.Mutex
+	generation uint64
+	facets     *models.FacetsResponse
+
+	// computeMutex makes sure the facets are computed only once at a time.
+	computeMutex sync.Mutex
+}
+
+// facetCounter counts the occurrences of the values of a single facet.
+type facetCounter map[string]uint64
+
+func (f *facetsCache) get() (*models.FacetsResponse, uint64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.facets, f.generation
+}
+
+// set caches the facets, unless the collection was modified since the given
+// generation.
+func (f *facetsCache) set(facets *models.FacetsResponse, generation uint64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if generation == f.generation {
+		f.facets = facets
+	}
+}
+
+func (f *facetsCache) invalidate() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.generation++
+	f.facets = nil
+}
+
+// getFacets gets the facets of the collection, computing them if they are not
+// cached.
+func (c *serverContext) getFacets(ctx context.Context) (*models.FacetsResponse, error) {
+	if facets, _ := c.facets.get(); nil != facets {
+		return facets, nil
+	}
+
+	c.facets.computeMutex.Lock()
+	defer c.facets.computeMutex.Unlock()
+
+	// Another request may have computed the facets in the meantime.
+	facets, generation := c.facets.get()
+	if nil != facets {
+		return facets, nil
+	}
+
+	facets, err := c.computeFacets(ctx)
+	if nil != err {
+		return nil, err
+	}
+
+	c.facets.set(facets, generation)
+
+	return facets, nil
+}
+
+// computeFacets scrolls through the whole collection and counts the photos per
+// year, month, camera make and model, and top-level folder.
+func (c *serverContext) computeFacets(ctx context.Context) (*models.FacetsResponse, error) {
+	start := time.Now()
+	years := make(facetCounter)
+	months := make(facetCounter)
+	makes := make(facetCounter)
+	cameraModels := make(facetCounter)
+	folders := make(facetCounter)
+
+	params := scrollParams{
+		PageSize: FACETS_PAGE_SIZE,
+		Fields: []string{
+			METADATA_PATH,
+			METADATA_TIMESTAMP,
+			exifKey(EXIF_CAMERA_MAKE),
+			exifKey(EXIF_CAMERA_Model),
+		},
+	}
+
+	for {
+		points, nextOffset, err := c.store.Scroll(ctx, params)
+		if nil != err {
+			glog.Errorf("Failed to scroll points for facets: %v", err)
+			return nil, err
+		}
+
+		for _, point := range points {
+			if timestamp := getTimestampFromPayload(point.Payload); nil != timestamp {
+				t := time.Unix(*timestamp, 0).UTC()
+				years.add(t.Format("2006"))
+				months.add(t.Format("2006-01"))
+			}
+
+			if field := getExifFieldFromPayload(point.Payload, EXIF_CAMERA_MAKE); nil != field {
+				makes.add(field.GetStringValue())
+			}
+			if field := getExifFieldFromPayload(point.Payload, EXIF_CAMERA_Model); nil != field {
+				cameraModels.add(field.GetStringValue())
+			}
+
+			folder, _, found := strings.Cut(*getPathFromPayload(point.Payload), "/")
+			if !found {
+				// The photo is in the root directory.
+				folder = ""
+			}
+			folders.add(folder)
+		}
+
+		if nil == nextOffset {
+			break
+		}
+		params.Offset = nextOffset
+	}
+
+	glog.V(1).Infof("Computed facets in %v.", time.Since(start))
+
+	return &models.FacetsResponse{
+		Years:        years.byValue(),
+		Months:       months.byValue(),
+		CameraMakes:  makes.byCount(),
+		CameraModels: cameraModels.byCount(),
+		Folders:      folders.byCount(),
+	}, nil
+}
+
+func (f facetCounter) add(value string) {
+	f[value]++
+}
+
+// byValue gets the counts, ordered by ascending value.
+func (f facetCounter) byValue() []*models.FacetCount {
+	counts := f.counts()
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Value < counts[j].Value
+	})
+
+	return counts
+}
+
+// byCount gets the counts, ordered by descending count.
+func (f facetCounter) byCount() []*models.FacetCount {
+	counts := f.counts()
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+
+	return counts
+}
+
+func (f facetCounter) counts() []*models.FacetCount {
+	counts := make([]*models.FacetCount, 0, len(f))
+	for value, count := range f {
+		counts = append(counts, &models.FacetCount{Value: value, Count: count})
+	}
+
+	return counts
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	// The files of the GeoNames dump (https://download.geonames.org/export/dump/)
+	// with the names of regions and countries, expected next to the cities.
+	GAZETTEER_ADMIN1_FILE    = "admin1CodesASCII.txt"
+	GAZETTEER_COUNTRIES_FILE = "countryInfo.txt"
+
+	// GAZETTEER_MAX_DISTANCE is the maximum distance to the nearest city, in
+	// meters, for a location to be attributed to that city.
+	GAZETTEER_MAX_DISTANCE = 50000
+	// GAZETTEER_CELL_SIZE is the size of the grid cells used to index the
+	// cities, in degrees.
+	GAZETTEER_CELL_SIZE = 1.0
+)
+
+// gazetteer resolves locations to the names of the nearest city, its region
+// and country, from an offline GeoNames cities dump.
+type gazetteer struct {
+	// cells holds the cities by the cell of the grid they are located in.
+	cells map[[2]int][]*gazetteerCity
+}
+
+type gazetteerCity struct {
+	name        string
+	latitude    float64
+	longitude   float64
+	region      string
+	country     string
+	countryCode string
+}
+
+// loadGazetteer loads the cities from the given GeoNames cities dump, like
+// cities15000.txt, resolving region and country names from the GeoNames files
+// in the same directory, if present.
+func loadGazetteer(citiesPath string) (*gazetteer, error) {
+	start := time.Now()
+	dir := filepath.Dir(citiesPath)
+
+	// The names of regions and countries are optional; without them, their
+	// codes are used.
+	regions, err := readGeoNamesFile(filepath.Join(dir, GAZETTEER_ADMIN1_FILE), 0, 1)
+	if nil != err {
+		glog.Warningf("Failed to read region names, using region codes: %v", err)
+	}
+	countries, err := readGeoNamesFile(filepath.Join(dir, GAZETTEER_COUNTRIES_FILE), 0, 4)
+	if nil != err {
+		glog.Warningf("Failed to read country names, using country codes: %v", err)
+	}
+
+	file, err := os.Open(citiesPath)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+
+	g := &gazetteer{cells: make(map[[2]int][]*gazetteerCity)}
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// See https://download.geonames.org/export/dump/readme.txt for the
+		// columns of the cities dump.
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) < 11 {
+			continue
+		}
+
+		lat, latErr := strconv.ParseFloat(cols[4], 64)
+		lon, lonErr := strconv.ParseFloat(cols[5], 64)
+		if nil != latErr || nil != lonErr {
+			continue
+		}
+
+		countryCode := cols[8]
+		country, found := countries[countryCode]
+		if !found {
+			country = countryCode
+		}
+		region := regions[countryCode+"."+cols[10]]
+
+		city := &gazetteerCity{
+			name:        cols[1],
+			latitude:    lat,
+			longitude:   lon,
+			region:      region,
+			country:     country,
+			countryCode: countryCode,
+		}
+		key := gazetteerCellKey(lat, lon)
+		g.cells[key] = append(g.cells[key], city)
+		count++
+	}
+
+	if err := scanner.Err(); nil != err {
+		return nil, err
+	}
+
+	glog.Infof("Loaded %d cities from gazetteer '%s' in %v.", count, citiesPath, time.Since(start))
+
+	return g, nil
+}
+
+// readGeoNamesFile reads a tab-separated GeoNames file into a map from the
+// values in one column to the values in another, skipping comments.
+func readGeoNamesFile(path string, keyCol, valueCol int) (map[string]string, error) {
+	file, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		cols := strings.Split(line, "\t")
+		if len(cols) > max(keyCol, valueCol) {
+			result[cols[keyCol]] = cols[valueCol]
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+func gazetteerCellKey(lat, lon float64) [2]int {
+	return [2]int{
+		int(math.Floor(lat / GAZETTEER_CELL_SIZE)),
+		int(math.Floor(lon / GAZETTEER_CELL_SIZE)),
+	}
+}
+
+// lookup finds the place of the nearest city to the given location, if there
+// is one within GAZETTEER_MAX_DISTANCE.
+func (g *gazetteer) lookup(location *models.Location) *models.Place {
+	if nil == g {
+		return nil
+	}
+
+	// The cells to search, such that all cities within the maximum distance
+	// are covered; longitudes get closer towards the poles.
+	latRange := GAZETTEER_MAX_DISTANCE / (GEO_EARTH_RADIUS * math.Pi / 180)
+	cosLat := math.Cos(math.Min(89, math.Abs(location.Latitude)+latRange) * math.Pi / 180)
+	lonRange := math.Min(180, latRange/cosLat)
+	minCell := gazetteerCellKey(location.Latitude-latRange, location.Longitude-lonRange)
+	maxCell := gazetteerCellKey(location.Latitude+latRange, location.Longitude+lonRange)
+	lonCells := int(math.Round(360 / GAZETTEER_CELL_SIZE))
+
+	var nearest *gazetteerCity
+	nearestDistance := math.Inf(1)
+	for latCell := minCell[0]; latCell <= maxCell[0]; latCell++ {
+		for lonCell := minCell[1]; lonCell <= maxCell[1] && lonCell-minCell[1] < lonCells; lonCell++ {
+			// Wrap around the antimeridian.
+			half := lonCells / 2
+			wrapped := ((lonCell+half)%lonCells+lonCells)%lonCells - half
+
+			for _, city := range g.cells[[2]int{latCell, wrapped}] {
+				distance := geoDistance(location.Latitude, location.Longitude,
+					city.latitude, city.longitude)
+				if distance < nearestDistance {
+					nearest = city
+					nearestDistance = distance
+				}
+			}
+		}
+	}
+
+	if nil == nearest || nearestDistance > GAZETTEER_MAX_DISTANCE {
+		return nil
+	}
+
+	return &models.Place{
+		City:        nearest.name,
+		Region:      nearest.region,
+		Country:     nearest.country,
+		CountryCode: nearest.countryCode,
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func writeTestGazetteer(t *testing.T, withNames bool) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	write := func(name string, lines ...string) {
+		content := strings.Join(lines, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); nil != err {
+			t.Fatalf("writing '%s' failed: %v", name, err)
+		}
+	}
+
+	write("cities15000.txt",
+		"2657896\tZurich\tZurich\t\t47.36667\t8.55\tP\tPPLA\tCH\t\tZH",
+		"2661552\tBern\tBern\t\t46.94809\t7.44744\tP\tPPLC\tCH\t\tBE",
+		"2660646\tGeneva\tGeneva\t\t46.20222\t6.14569\tP\tPPLA\tCH\t\tGE",
+		"9999999\tEastside\tEastside\t\t-16.8\t179.95\tP\tPPL\tFJ\t\t03",
+		"malformed line",
+		"9999998\tNowhere\tNowhere\t\tnorth\teast\tP\tPPL\tXX\t\t01")
+	if withNames {
+		write(GAZETTEER_ADMIN1_FILE,
+			"CH.ZH\tZurich\tZurich\t2657895",
+			"CH.BE\tBern\tBern\t2661551")
+		write(GAZETTEER_COUNTRIES_FILE,
+			"# ISO\tISO3\tISO-Numeric\tfips\tCountry",
+			"CH\tCHE\t756\tSZ\tSwitzerland",
+			"FJ\tFJI\t242\tFJ\tFiji")
+	}
+
+	return filepath.Join(dir, "cities15000.txt")
+}
+
+func TestGazetteerLookup(t *testing.T) {
+	g, err := loadGazetteer(writeTestGazetteer(t, true))
+	if nil != err {
+		t.Fatalf("loadGazetteer() failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		location models.Location
+		expected *models.Place
+	}{
+		{"at city", models.Location{Latitude: 47.36667, Longitude: 8.55},
+			&models.Place{City: "Zurich", Region: "Zurich", Country: "Switzerland", CountryCode: "CH"}},
+		{"nearest city", models.Location{Latitude: 47.0, Longitude: 7.5},
+			&models.Place{City: "Bern", Region: "Bern", Country: "Switzerland", CountryCode: "CH"}},
+		{"neighbouring cell", models.Location{Latitude: 47.5, Longitude: 9.0},
+			&models.Place{City: "Zurich", Region: "Zurich", Country: "Switzerland", CountryCode: "CH"}},
+		{"unknown region", models.Location{Latitude: 46.2, Longitude: 6.1},
+			&models.Place{City: "Geneva", Country: "Switzerland", CountryCode: "CH"}},
+		{"across antimeridian", models.Location{Latitude: -16.8, Longitude: -179.95},
+			&models.Place{City: "Eastside", Country: "Fiji", CountryCode: "FJ"}},
+		{"too far", models.Location{Latitude: 45.0, Longitude: -30.0}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := g.lookup(&test.location)
+			if (nil == test.expected) != (nil == actual) ||
+				(nil != actual && *test.expected != *actual) {
+				t.Errorf("lookup() = %+v, expected %+v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestGazetteerWithoutNames(t *testing.T) {
+	g, err := loadGazetteer(writeTestGazetteer(t, false))
+	if nil != err {
+		t.Fatalf("loadGazetteer() failed: %v", err)
+	}
+
+	expected := models.Place{City: "Zurich", Country: "CH", CountryCode: "CH"}
+	if actual := g.lookup(&models.Location{Latitude: 47.4, Longitude: 8.5}); nil == actual || expected != *actual {
+		t.Errorf("lookup() = %+v, expected %+v", actual, expected)
+	}
+
+	var none *gazetteer
+	if actual := none.lookup(&models.Location{Latitude: 47.4, Longitude: 8.5}); nil != actual {
+		t.Errorf("lookup() without gazetteer = %+v, expected nil", actual)
+	}
+
+	if _, err := loadGazetteer(filepath.Join(t.TempDir(), "missing.txt")); nil == err {
+		t.Error("loadGazetteer() succeeded for a missing file")
+	}
+}
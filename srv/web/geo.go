@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	GEO_LATITUDE  = "lat"
+	GEO_LONGITUDE = "lon"
+
+	// GEO_EARTH_RADIUS is the mean radius of the earth, in meters.
+	GEO_EARTH_RADIUS = 6371008.8
+
+	GEO_CLUSTERS_PAGE_SIZE = 1000
+	GEO_MAX_ZOOM           = 24
+	// GEO_CLUSTER_CELLS_PER_TILE is the number of grid cells per map tile
+	// along each axis; with tiles of 256 pixels, clusters are 64 pixels apart.
+	GEO_CLUSTER_CELLS_PER_TILE = 4
+)
+
+// makeGeoPayloadValue makes the value of the geo payload field for the given
+// location, in the format indexed by qdrant.
+func makeGeoPayloadValue(location *models.Location) *pb.Value {
+	return &pb.Value{
+		Kind: &pb.Value_StructValue{
+			StructValue: &pb.Struct{
+				Fields: map[string]*pb.Value{
+					GEO_LATITUDE: {
+						Kind: &pb.Value_DoubleValue{DoubleValue: location.Latitude},
+					},
+					GEO_LONGITUDE: {
+						Kind: &pb.Value_DoubleValue{DoubleValue: location.Longitude},
+					},
+				},
+			},
+		},
+	}
+}
+
+// getGeoPointFromValue gets the latitude and longitude from a geo payload
+// value.
+func getGeoPointFromValue(value *pb.Value) (float64, float64, bool) {
+	fields := value.GetStructValue().GetFields()
+	lat, latOk := fields[GEO_LATITUDE]
+	lon, lonOk := fields[GEO_LONGITUDE]
+	if !latOk || !lonOk {
+		return 0, 0, false
+	}
+
+	latVal, latOk := getNumericValue(lat)
+	lonVal, lonOk := getNumericValue(lon)
+
+	return latVal, lonVal, latOk && lonOk
+}
+
+// placeKey gets the payload key of the given place field.
+func placeKey(field string) string {
+	return METADATA_PLACE + "." + field
+}
+
+func makePlacePayloadValue(place *models.Place) *pb.Value {
+	fields := make(map[string]*pb.Value)
+	for key, value := range map[string]string{
+		PLACE_CITY:         place.City,
+		PLACE_REGION:       place.Region,
+		PLACE_COUNTRY:      place.Country,
+		PLACE_COUNTRY_CODE: place.CountryCode,
+	} {
+		if "" != value {
+			fields[key] = &pb.Value{Kind: &pb.Value_StringValue{StringValue: value}}
+		}
+	}
+
+	return &pb.Value{
+		Kind: &pb.Value_StructValue{StructValue: &pb.Struct{Fields: fields}},
+	}
+}
+
+func getPlaceFromPayload(payload map[string]*pb.Value) *models.Place {
+	fields := payload[METADATA_PLACE].GetStructValue().GetFields()
+	if len(fields) < 1 {
+		return nil
+	}
+
+	return &models.Place{
+		City:        fields[PLACE_CITY].GetStringValue(),
+		Region:      fields[PLACE_REGION].GetStringValue(),
+		Country:     fields[PLACE_COUNTRY].GetStringValue(),
+		CountryCode: fields[PLACE_COUNTRY_CODE].GetStringValue(),
+	}
+}
+
+// makePlacesCondition makes a condition for photos taken in any of the given
+// places, each of which may be a city, region, country or country code.
+func makePlacesCondition(places []string) *pb.Condition {
+	fields := []string{PLACE_CITY, PLACE_REGION, PLACE_COUNTRY, PLACE_COUNTRY_CODE}
+	should := make([]*pb.Condition, len(fields))
+	for i, field := range fields {
+		should[i] = &pb.Condition{
+			ConditionOneOf: &pb.Condition_Field{
+				Field: &pb.FieldCondition{
+					Key: placeKey(field),
+					Match: &pb.Match{
+						MatchValue: &pb.Match_Keywords{
+							Keywords: &pb.RepeatedStrings{Strings: places},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Filter{
+			Filter: &pb.Filter{Should: should},
+		},
+	}
+}
+
+func makeGeoBoundingBoxCondition(bbox *models.GeoBoundingBox) *pb.Condition {
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Field{
+			Field: &pb.FieldCondition{
+				Key: METADATA_GEO,
+				GeoBoundingBox: &pb.GeoBoundingBox{
+					TopLeft:     &pb.GeoPoint{Lat: bbox.North, Lon: bbox.West},
+					BottomRight: &pb.GeoPoint{Lat: bbox.South, Lon: bbox.East},
+				},
+			},
+		},
+	}
+}
+
+func makeGeoRadiusCondition(radius *models.GeoRadius) *pb.Condition {
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Field{
+			Field: &pb.FieldCondition{
+				Key: METADATA_GEO,
+				GeoRadius: &pb.GeoRadius{
+					Center: &pb.GeoPoint{Lat: radius.Latitude, Lon: radius.Longitude},
+					Radius: float32(radius.Radius),
+				},
+			},
+		},
+	}
+}
+
+// isInGeoBoundingBox checks if the point is in the bounding box, which may
+// cross the antimeridian, in which case its west is east of its east.
+func isInGeoBoundingBox(lat, lon float64, bbox *pb.GeoBoundingBox) bool {
+	north, west := bbox.GetTopLeft().GetLat(), bbox.GetTopLeft().GetLon()
+	south, east := bbox.GetBottomRight().GetLat(), bbox.GetBottomRight().GetLon()
+
+	if lat > north || lat < south {
+		return false
+	}
+
+	if west <= east {
+		return lon >= west && lon <= east
+	}
+
+	return lon >= west || lon <= east
+}
+
+// geoDistance calculates the great-circle distance between two points, in
+// meters.
+func geoDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+
+	return 2 * GEO_EARTH_RADIUS * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// parseGeoBoundingBox parses a bounding box given as 'west,south,east,north'
+// in decimal degrees.
+func parseGeoBoundingBox(s string) (*models.GeoBoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, InvalidGeoQuery
+	}
+
+	var coords [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if nil != err || math.IsNaN(f) {
+			return nil, InvalidGeoQuery
+		}
+		coords[i] = f
+	}
+
+	bbox := &models.GeoBoundingBox{
+		West:  coords[0],
+		South: coords[1],
+		East:  coords[2],
+		North: coords[3],
+	}
+
+	if bbox.South > bbox.North || bbox.South < -90 || bbox.North > 90 ||
+		bbox.West < -180 || bbox.West > 180 || bbox.East < -180 || bbox.East > 180 {
+		return nil, InvalidGeoQuery
+	}
+
+	return bbox, nil
+}
+
+// geoCluster accumulates the photos in a single grid cell.
+type geoCluster struct {
+	cluster *models.GeoCluster
+	sumLat  float64
+	sumLon  float64
+}
+
+// getGeoClusters counts the photos within the bounding box, grouped by the
+// cells of a grid whose size depends on the map's zoom level.
+func (c *serverContext) getGeoClusters(
+	ctx context.Context,
+	bbox *models.GeoBoundingBox,
+	zoom int,
+) (*models.GeoClustersResponse, error) {
+	start := time.Now()
+	cellSize := 360 / (math.Exp2(float64(zoom)) * GEO_CLUSTER_CELLS_PER_TILE)
+	cells := make(map[[2]int]*geoCluster)
+
+	params := scrollParams{
+		PageSize: GEO_CLUSTERS_PAGE_SIZE,
+		Filter: &pb.Filter{
+			Must: []*pb.Condition{makeGeoBoundingBoxCondition(bbox)},
+		},
+		Fields: []string{METADATA_GEO},
+	}
+
+	for {
+		points, nextOffset, err := c.store.Scroll(ctx, params)
+		if nil != err {
+			glog.Errorf("Failed to scroll points for geo clusters: %v", err)
+			return nil, err
+		}
+
+		for _, point := range points {
+			lat, lon, ok := getGeoPointFromValue(point.Payload[METADATA_GEO])
+			if !ok {
+				continue
+			}
+
+			key := [2]int{
+				int(math.Floor((lat + 90) / cellSize)),
+				int(math.Floor((lon + 180) / cellSize)),
+			}
+			cell, found := cells[key]
+			if !found {
+				cell = &geoCluster{
+					cluster: &models.GeoCluster{
+						Id: point.Id.GetUuid(),
+						Bounds: &models.GeoBoundingBox{
+							North: lat, South: lat, East: lon, West: lon,
+						},
+					},
+				}
+				cells[key] = cell
+			}
+			cell.add(lat, lon)
+		}
+
+		if nil == nextOffset {
+			break
+		}
+		params.Offset = nextOffset
+	}
+
+	clusters := make([]*models.GeoCluster, 0, len(cells))
+	for _, cell := range cells {
+		cell.cluster.Latitude = cell.sumLat / float64(cell.cluster.Count)
+		cell.cluster.Longitude = cell.sumLon / float64(cell.cluster.Count)
+		clusters = append(clusters, cell.cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
+		}
+		return clusters[i].Id < clusters[j].Id
+	})
+
+	glog.V(1).Infof("Computed %d geo clusters at zoom %d in %v.",
+		len(clusters), zoom, time.Since(start))
+
+	return &models.GeoClustersResponse{Clusters: clusters}, nil
+}
+
+func (g *geoCluster) add(lat, lon float64) {
+	g.cluster.Count++
+	g.sumLat += lat
+	g.sumLon += lon
+
+	bounds := g.cluster.Bounds
+	bounds.North = math.Max(bounds.North, lat)
+	bounds.South = math.Min(bounds.South, lat)
+	bounds.East = math.Max(bounds.East, lon)
+	bounds.West = math.Min(bounds.West, lon)
+}
@@ -11,15 +11,22 @@ require (
 	google.golang.org/grpc v1.81.1 // direct
 )
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	github.com/chai2010/webp v1.4.0
+	github.com/gen2brain/heic v0.4.5
+	go.etcd.io/bbolt v1.5.0
+	google.golang.org/protobuf v1.36.11
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
+	github.com/ebitengine/purego v0.10.0 // indirect
 	github.com/go-jose/go-jose/v4 v4.1.4 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
 	golang.org/x/image v0.43.0 // indirect
 	golang.org/x/net v0.56.0 // indirect
 	golang.org/x/oauth2 v0.36.0 // indirect
 	golang.org/x/sys v0.46.0 // indirect
 	golang.org/x/text v0.38.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20260618152121-87f3d3e198d3 // indirect
-	google.golang.org/protobuf v1.36.11 // indirect
 )
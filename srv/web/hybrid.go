@@ -0,0 +1,185 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+const (
+	SEARCH_MODE_VECTOR = "vector"
+	SEARCH_MODE_HYBRID = "hybrid"
+
+	// HYBRID_CANDIDATES is the minimum number of results of each of the vector
+	// and keyword queries that are fused for hybrid search.
+	HYBRID_CANDIDATES = 200
+	// HYBRID_RRF_K is the constant of reciprocal rank fusion, which dampens
+	// the influence of the top ranks.
+	HYBRID_RRF_K = 60
+)
+
+var quotedPhrasesRegex = regexp.MustCompile(`"([^"]*)"`)
+
+// hybridQuery is the keyword part of a hybrid search query.
+type hybridQuery struct {
+	// terms holds the lowercase words of the query, any of which should match.
+	terms []string
+	// phrases holds the quoted phrases of the query, all of which must match.
+	phrases []string
+}
+
+// parseHybridQuery splits a query into its words and its quoted phrases.
+func parseHybridQuery(query string) hybridQuery {
+	var q hybridQuery
+	for _, match := range quotedPhrasesRegex.FindAllStringSubmatch(query, -1) {
+		if phrase := strings.TrimSpace(match[1]); "" != phrase {
+			q.phrases = append(q.phrases, phrase)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, term := range tokenizeText(query) {
+		if !seen[term] {
+			seen[term] = true
+			q.terms = append(q.terms, term)
+		}
+	}
+
+	return q
+}
+
+// tokenizeText splits text into lowercase words, like qdrant's word tokenizer
+// used for the full-text index on the path.
+func tokenizeText(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// embeddingText gets the text of a query to calculate the embedding for.
+func embeddingText(query string) string {
+	return strings.TrimSpace(strings.ReplaceAll(query, `"`, ""))
+}
+
+// hybridSearch runs a vector query, and the same vector query restricted to
+// photos whose path matches any of the keywords, ranked by the number of
+// matching keywords first. It fuses both rankings with reciprocal rank fusion,
+// so the scores of the results are their fused scores, not similarities.
+func (c *serverContext) hybridSearch(
+	vector []float32,
+	query hybridQuery,
+	params searchParams,
+) ([]*pb.ScoredPoint, error) {
+	must := make([]*pb.Condition, 0, len(query.phrases)+1)
+	if nil != params.Filter {
+		must = append(must, &pb.Condition{
+			ConditionOneOf: &pb.Condition_Filter{Filter: params.Filter},
+		})
+	}
+	for _, phrase := range query.phrases {
+		must = append(must, makeTextCondition(METADATA_PATH, phrase))
+	}
+
+	candidates := searchParams{
+		Limit:  max(HYBRID_CANDIDATES, params.Offset+params.Limit),
+		Filter: &pb.Filter{Must: must},
+	}
+	vectorResults, err := c.store.Search(vector, candidates)
+	if nil != err {
+		return nil, err
+	}
+
+	var keywordResults []*pb.ScoredPoint
+	if len(query.terms) > 0 {
+		should := make([]*pb.Condition, len(query.terms))
+		for i, term := range query.terms {
+			should[i] = makeTextCondition(METADATA_PATH, term)
+		}
+		candidates.Filter = &pb.Filter{Must: must, Should: should}
+
+		// The keyword matches are ranked by the number of matching terms, and
+		// by their similarity for the same number of matching terms.
+		keywordResults, err = c.store.Search(vector, candidates)
+		if nil != err {
+			return nil, err
+		}
+		matches := make(map[string]int, len(keywordResults))
+		for _, point := range keywordResults {
+			matches[point.Id.GetUuid()] = countMatchingTerms(point.Payload, query.terms)
+		}
+		sort.SliceStable(keywordResults, func(i, j int) bool {
+			return matches[keywordResults[i].Id.GetUuid()] > matches[keywordResults[j].Id.GetUuid()]
+		})
+	}
+
+	fused := fuseRankings(vectorResults, keywordResults)
+	if params.Offset >= uint64(len(fused)) {
+		return nil, nil
+	}
+
+	return fused[params.Offset:min(uint64(len(fused)), params.Offset+params.Limit)], nil
+}
+
+// countMatchingTerms counts the terms found in the path of a photo.
+func countMatchingTerms(payload map[string]*pb.Value, terms []string) int {
+	words := make(map[string]bool)
+	for _, word := range tokenizeText(*getPathFromPayload(payload)) {
+		words[word] = true
+	}
+
+	count := 0
+	for _, term := range terms {
+		if words[term] {
+			count++
+		}
+	}
+
+	return count
+}
+
+// fuseRankings fuses the given rankings with reciprocal rank fusion, ordering
+// the results by descending fused score, then by ID.
+func fuseRankings(rankings ...[]*pb.ScoredPoint) []*pb.ScoredPoint {
+	scores := make(map[string]float64)
+	points := make(map[string]*pb.ScoredPoint)
+	for _, ranking := range rankings {
+		for rank, point := range ranking {
+			id := point.Id.GetUuid()
+			scores[id] += 1 / float64(HYBRID_RRF_K+rank+1)
+			points[id] = point
+		}
+	}
+
+	fused := make([]*pb.ScoredPoint, 0, len(points))
+	for id, point := range points {
+		fused = append(fused, &pb.ScoredPoint{
+			Id:      point.Id,
+			Payload: point.Payload,
+			Score:   float32(scores[id]),
+		})
+	}
+	sort.Slice(fused, func(i, j int) bool {
+		if fused[i].Score != fused[j].Score {
+			return fused[i].Score > fused[j].Score
+		}
+		return fused[i].Id.GetUuid() < fused[j].Id.GetUuid()
+	})
+
+	return fused
+}
+
+func makeTextCondition(key, text string) *pb.Condition {
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Field{
+			Field: &pb.FieldCondition{
+				Key: key,
+				Match: &pb.Match{
+					MatchValue: &pb.Match_Text{Text: text},
+				},
+			},
+		},
+	}
+}
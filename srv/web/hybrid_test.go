@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+func testScoredPoints(ids ...string) []*pb.ScoredPoint {
+	points := make([]*pb.ScoredPoint, len(ids))
+	for i, id := range ids {
+		points[i] = &pb.ScoredPoint{
+			Id:      makePointId(id),
+			Payload: pb.NewValueMap(map[string]any{"path": id + ".jpg"}),
+			Score:   1 - float32(i)/10,
+		}
+	}
+
+	return points
+}
+
+func TestFuseRankings(t *testing.T) {
+	rrf := func(ranks ...int) float32 {
+		var score float64
+		for _, rank := range ranks {
+			score += 1 / float64(HYBRID_RRF_K+rank)
+		}
+		return float32(score)
+	}
+
+	tests := []struct {
+		name     string
+		rankings [][]*pb.ScoredPoint
+		expected []string
+		scores   []float32
+	}{
+		{"no rankings", nil, []string{}, []float32{}},
+		{"single ranking",
+			[][]*pb.ScoredPoint{testScoredPoints("a", "b")},
+			[]string{"a", "b"}, []float32{rrf(1), rrf(2)}},
+		{"fused",
+			[][]*pb.ScoredPoint{testScoredPoints("a", "b", "c"), testScoredPoints("c", "d")},
+			// b and d tie, and are ordered by ID.
+			[]string{"c", "a", "b", "d"}, []float32{rrf(3, 1), rrf(1), rrf(2), rrf(2)}},
+		{"empty ranking",
+			[][]*pb.ScoredPoint{testScoredPoints("b", "a"), nil},
+			[]string{"b", "a"}, []float32{rrf(1), rrf(2)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fused := fuseRankings(test.rankings...)
+
+			ids := make([]string, len(fused))
+			scores := make([]float32, len(fused))
+			for i, point := range fused {
+				ids[i] = point.Id.GetUuid()
+				scores[i] = point.Score
+				if *getPathFromPayload(point.Payload) != ids[i]+".jpg" {
+					t.Errorf("fused point '%s' has payload %v", ids[i], point.Payload)
+				}
+			}
+
+			if !slices.Equal(test.expected, ids) {
+				t.Errorf("fuseRankings() = %v, expected %v", ids, test.expected)
+			}
+			if !slices.EqualFunc(test.scores, scores, func(a, b float32) bool {
+				return math.Abs(float64(a-b)) < 1e-6
+			}) {
+				t.Errorf("fuseRankings() scores = %v, expected %v", scores, test.scores)
+			}
+		})
+	}
+}
+
+func TestParseHybridQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		terms   []string
+		phrases []string
+	}{
+		{"", nil, nil},
+		{"Beach sunset", []string{"beach", "sunset"}, nil},
+		{"beach, BEACH; sunset!", []string{"beach", "sunset"}, nil},
+		{`"Tuscany Wedding" dinner`, []string{"tuscany", "wedding", "dinner"}, []string{"Tuscany Wedding"}},
+		{`"" "  " zürich 2024`, []string{"zürich", "2024"}, nil},
+	}
+
+	for _, test := range tests {
+		q := parseHybridQuery(test.query)
+		if !slices.Equal(test.terms, q.terms) || !slices.Equal(test.phrases, q.phrases) {
+			t.Errorf("parseHybridQuery('%s') = %v, %v, expected %v, %v",
+				test.query, q.terms, q.phrases, test.terms, test.phrases)
+		}
+	}
+}
+
+func TestCountMatchingTerms(t *testing.T) {
+	payload := pb.NewValueMap(map[string]any{"path": "2024/Tuscany-Wedding/IMG_0001.jpg"})
+
+	tests := []struct {
+		terms    []string
+		expected int
+	}{
+		{nil, 0},
+		{[]string{"tuscany"}, 1},
+		{[]string{"tuscany", "wedding", "beach"}, 2},
+		{[]string{"tus"}, 0},
+	}
+
+	for _, test := range tests {
+		if actual := countMatchingTerms(payload, test.terms); test.expected != actual {
+			t.Errorf("countMatchingTerms(%v) = %d, expected %d", test.terms, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// imageDecoder decodes photos in the formats identified by its extensions.
+type imageDecoder struct {
+	name       string
+	extensions []string
+	mediaType  string
+	// browserSupported tells whether browsers can display the original files;
+	// if not, full-size photos are transcoded before they are served.
+	browserSupported bool
+	// autoOriented tells whether decoded images already have the orientation
+	// from the metadata applied.
+	autoOriented bool
+	decode       func(path string) (image.Image, error)
+}
+
+var (
+	defaultImageDecoder = &imageDecoder{
+		name:             "default",
+		mediaType:        MEDIA_TYPE_PHOTO,
+		browserSupported: true,
+		decode: func(path string) (image.Image, error) {
+			return imaging.Open(path)
+		},
+	}
+
+	// imageDecoders holds the registered decoders by (lower case) extension.
+	imageDecoders = make(map[string]*imageDecoder)
+)
+
+// registerImageDecoder adds support for decoding more photo formats.
+func registerImageDecoder(decoder *imageDecoder) {
+	for _, ext := range decoder.extensions {
+		imageDecoders[strings.ToLower(ext)] = decoder
+	}
+}
+
+// getImageDecoder gets the decoder to use for the photo at the given path.
+func getImageDecoder(path string) *imageDecoder {
+	decoder, found := imageDecoders[strings.ToLower(filepath.Ext(path))]
+	if !found {
+		return defaultImageDecoder
+	}
+
+	return decoder
+}
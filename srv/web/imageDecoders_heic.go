@@ -0,0 +1,28 @@
+package main
+
+import (
+	"image"
+	"os"
+
+	"github.com/gen2brain/heic"
+)
+
+func init() {
+	registerImageDecoder(&imageDecoder{
+		name:       "heic",
+		extensions: []string{".heic", ".heif"},
+		mediaType:  MEDIA_TYPE_PHOTO,
+		// libheif applies the image's transformations while decoding.
+		autoOriented: true,
+		decode: func(path string) (image.Image, error) {
+			file, err := os.Open(path)
+			if nil != err {
+				return nil, err
+			}
+
+			defer file.Close()
+
+			return heic.Decode(file)
+		},
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+const (
+	TIFF_TAG_COMPRESSION             = 0x0103
+	TIFF_TAG_STRIP_OFFSETS           = 0x0111
+	TIFF_TAG_STRIP_BYTE_COUNTS       = 0x0117
+	TIFF_TAG_SUB_IFDS                = 0x014a
+	TIFF_TAG_JPEG_INTERCHANGE_FORMAT = 0x0201
+	TIFF_TAG_JPEG_INTERCHANGE_LENGTH = 0x0202
+
+	TIFF_TYPE_SHORT = 3
+	TIFF_TYPE_LONG  = 4
+	TIFF_TYPE_IFD   = 13
+
+	// Limit the number of IFDs to visit, to guard against malformed files.
+	TIFF_MAX_IFDS = 32
+)
+
+var NoRawPreviewFound = errors.New("no embedded preview found in RAW file")
+
+// rawPreview locates a JPEG stream embedded in a RAW file.
+type rawPreview struct {
+	offset int64
+	length int64
+}
+
+func init() {
+	// Most camera RAW formats are based on TIFF and embed (large) JPEG
+	// previews, which are good enough for viewing the photos.
+	registerImageDecoder(&imageDecoder{
+		name:       "raw",
+		extensions: []string{".cr2", ".nef", ".nrw", ".arw", ".dng", ".pef"},
+		mediaType:  MEDIA_TYPE_PHOTO,
+		decode:     decodeRawPreview,
+	})
+}
+
+// decodeRawPreview decodes the largest JPEG preview embedded in a TIFF-based
+// camera RAW file.
+func decodeRawPreview(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	previews, err := findRawPreviews(file)
+	if nil != err {
+		return nil, err
+	}
+
+	// Some of the JPEG streams may hold the (lossless) raw sensor data, which
+	// cannot be decoded; so try the largest previews first, until one works.
+	sort.Slice(previews, func(i, j int) bool {
+		return previews[i].length > previews[j].length
+	})
+
+	for _, preview := range previews {
+		img, err := jpeg.Decode(io.NewSectionReader(file, preview.offset, preview.length))
+		if nil == err {
+			return img, nil
+		}
+
+		glog.V(2).Infof("Failed to decode preview at offset %d in '%s': %v",
+			preview.offset, path, err)
+	}
+
+	return nil, NoRawPreviewFound
+}
+
+// findRawPreviews walks the TIFF structure of a RAW file and collects all
+// embedded JPEG streams.
+func findRawPreviews(r io.ReaderAt) ([]rawPreview, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); nil != err {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF-based RAW file")
+	}
+
+	var previews []rawPreview
+	visited := make(map[uint32]bool)
+	pending := []uint32{order.Uint32(header[4:])}
+
+	for len(pending) > 0 && len(visited) < TIFF_MAX_IFDS {
+		offset := pending[0]
+		pending = pending[1:]
+		if 0 == offset || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		ifd, next, err := readIFD(r, order, offset)
+		if nil != err {
+			glog.V(2).Infof("Failed to read IFD at offset %d: %v", offset, err)
+			continue
+		}
+		pending = append(pending, next)
+		pending = append(pending, ifd.values(r, order, TIFF_TAG_SUB_IFDS)...)
+
+		if preview, ok := ifd.preview(r, order); ok {
+			previews = append(previews, preview)
+		}
+	}
+
+	if len(previews) < 1 {
+		return nil, NoRawPreviewFound
+	}
+
+	return previews, nil
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // the raw 4 bytes holding the value(s) or their offset
+}
+
+type ifd map[uint16]ifdEntry
+
+func readIFD(r io.ReaderAt, order binary.ByteOrder, offset uint32) (ifd, uint32, error) {
+	buf := make([]byte, 2)
+	if _, err := r.ReadAt(buf, int64(offset)); nil != err {
+		return nil, 0, err
+	}
+
+	count := int(order.Uint16(buf))
+	buf = make([]byte, count*12+4)
+	if _, err := r.ReadAt(buf, int64(offset)+2); nil != err {
+		return nil, 0, err
+	}
+
+	result := make(ifd, count)
+	for i := 0; i < count; i++ {
+		entry := buf[i*12 : (i+1)*12]
+		result[order.Uint16(entry[0:2])] = ifdEntry{
+			typ:   order.Uint16(entry[2:4]),
+			count: order.Uint32(entry[4:8]),
+			value: entry[8:12],
+		}
+	}
+
+	return result, order.Uint32(buf[count*12:]), nil
+}
+
+// values reads the SHORT, LONG or IFD values of the entry with the given tag.
+func (d ifd) values(r io.ReaderAt, order binary.ByteOrder, tag uint16) []uint32 {
+	entry, found := d[tag]
+	if !found || entry.count > TIFF_MAX_IFDS {
+		return nil
+	}
+
+	size := 4
+	if TIFF_TYPE_SHORT == entry.typ {
+		size = 2
+	} else if TIFF_TYPE_LONG != entry.typ && TIFF_TYPE_IFD != entry.typ {
+		return nil
+	}
+
+	data := entry.value
+	if int(entry.count)*size > 4 {
+		data = make([]byte, int(entry.count)*size)
+		if _, err := r.ReadAt(data, int64(order.Uint32(entry.value))); nil != err {
+			return nil
+		}
+	}
+
+	values := make([]uint32, entry.count)
+	for i := range values {
+		if 2 == size {
+			values[i] = uint32(order.Uint16(data[i*2:]))
+		} else {
+			values[i] = order.Uint32(data[i*4:])
+		}
+	}
+
+	return values
+}
+
+func (d ifd) value(r io.ReaderAt, order binary.ByteOrder, tag uint16) (uint32, bool) {
+	values := d.values(r, order, tag)
+	if len(values) != 1 {
+		return 0, false
+	}
+
+	return values[0], true
+}
+
+// preview finds the JPEG stream described by the IFD, if any. The stream is
+// referenced either through the JPEGInterchangeFormat tags, or through a
+// single JPEG compressed strip.
+func (d ifd) preview(r io.ReaderAt, order binary.ByteOrder) (rawPreview, bool) {
+	offset, hasOffset := d.value(r, order, TIFF_TAG_JPEG_INTERCHANGE_FORMAT)
+	length, hasLength := d.value(r, order, TIFF_TAG_JPEG_INTERCHANGE_LENGTH)
+
+	if !hasOffset || !hasLength {
+		compression, _ := d.value(r, order, TIFF_TAG_COMPRESSION)
+		if 6 != compression && 7 != compression {
+			return rawPreview{}, false
+		}
+
+		offset, hasOffset = d.value(r, order, TIFF_TAG_STRIP_OFFSETS)
+		length, hasLength = d.value(r, order, TIFF_TAG_STRIP_BYTE_COUNTS)
+		if !hasOffset || !hasLength {
+			return rawPreview{}, false
+		}
+	}
+
+	// Make sure this really is the start of a JPEG stream.
+	soi := make([]byte, 2)
+	if _, err := r.ReadAt(soi, int64(offset)); nil != err || 0xff != soi[0] || 0xd8 != soi[1] {
+		return rawPreview{}, false
+	}
+
+	return rawPreview{offset: int64(offset), length: int64(length)}, true
+}
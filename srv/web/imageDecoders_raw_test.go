@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// testTiff builds TIFF structures for tests.
+type testTiff struct {
+	order binary.ByteOrder
+	data  []byte
+}
+
+type testIfdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value uint32
+}
+
+func newTestTiff(order binary.ByteOrder) *testTiff {
+	t := &testTiff{order: order}
+	if binary.LittleEndian == order {
+		t.data = []byte("II*\x00\x00\x00\x00\x00")
+	} else {
+		t.data = []byte("MM\x00*\x00\x00\x00\x00")
+	}
+
+	return t
+}
+
+func (t *testTiff) append(data []byte) uint32 {
+	offset := uint32(len(t.data))
+	t.data = append(t.data, data...)
+
+	return offset
+}
+
+func (t *testTiff) setUint32(offset, value uint32) {
+	t.order.PutUint32(t.data[offset:], value)
+}
+
+// appendIFD appends an IFD, and returns its offset and the offset of its
+// pointer to the next IFD.
+func (t *testTiff) appendIFD(entries ...testIfdEntry) (uint32, uint32) {
+	buf := make([]byte, 2+len(entries)*12+4)
+	t.order.PutUint16(buf, uint16(len(entries)))
+	for i, entry := range entries {
+		e := buf[2+i*12:]
+		t.order.PutUint16(e[0:], entry.tag)
+		t.order.PutUint16(e[2:], entry.typ)
+		t.order.PutUint32(e[4:], entry.count)
+		if TIFF_TYPE_SHORT == entry.typ && 1 == entry.count {
+			t.order.PutUint16(e[8:], uint16(entry.value))
+		} else {
+			t.order.PutUint32(e[8:], entry.value)
+		}
+	}
+
+	offset := t.append(buf)
+
+	return offset, offset + uint32(len(buf)) - 4
+}
+
+func testJpegStream(size int) []byte {
+	data := make([]byte, size)
+	data[0], data[1] = 0xff, 0xd8
+
+	return data
+}
+
+func TestFindRawPreviews(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			tiff := newTestTiff(order)
+			thumbnail := tiff.append(testJpegStream(100))
+			large := tiff.append(testJpegStream(1000))
+			notJpeg := tiff.append(make([]byte, 50))
+
+			// A sub IFD with a single JPEG compressed strip, and one without a
+			// JPEG stream.
+			stripIfd, _ := tiff.appendIFD(
+				testIfdEntry{TIFF_TAG_COMPRESSION, TIFF_TYPE_SHORT, 1, 7},
+				testIfdEntry{TIFF_TAG_STRIP_OFFSETS, TIFF_TYPE_LONG, 1, large},
+				testIfdEntry{TIFF_TAG_STRIP_BYTE_COUNTS, TIFF_TYPE_LONG, 1, 1000},
+			)
+			rawIfd, _ := tiff.appendIFD(
+				testIfdEntry{TIFF_TAG_COMPRESSION, TIFF_TYPE_SHORT, 1, 1},
+				testIfdEntry{TIFF_TAG_STRIP_OFFSETS, TIFF_TYPE_LONG, 1, notJpeg},
+				testIfdEntry{TIFF_TAG_STRIP_BYTE_COUNTS, TIFF_TYPE_LONG, 1, 50},
+			)
+			subIfds := make([]byte, 8)
+			order.PutUint32(subIfds, stripIfd)
+			order.PutUint32(subIfds[4:], rawIfd)
+			subIfdsOffset := tiff.append(subIfds)
+
+			ifd1, ifd1Next := tiff.appendIFD(
+				testIfdEntry{TIFF_TAG_JPEG_INTERCHANGE_FORMAT, TIFF_TYPE_LONG, 1, thumbnail},
+				testIfdEntry{TIFF_TAG_JPEG_INTERCHANGE_LENGTH, TIFF_TYPE_LONG, 1, 100},
+			)
+			ifd0, ifd0Next := tiff.appendIFD(
+				testIfdEntry{TIFF_TAG_SUB_IFDS, TIFF_TYPE_LONG, 2, subIfdsOffset},
+				// Not a JPEG stream.
+				testIfdEntry{TIFF_TAG_JPEG_INTERCHANGE_FORMAT, TIFF_TYPE_LONG, 1, notJpeg},
+				testIfdEntry{TIFF_TAG_JPEG_INTERCHANGE_LENGTH, TIFF_TYPE_LONG, 1, 50},
+			)
+			tiff.setUint32(4, ifd0)
+			tiff.setUint32(ifd0Next, ifd1)
+			// A cycle back to the first IFD must not loop forever.
+			tiff.setUint32(ifd1Next, ifd0)
+
+			previews, err := findRawPreviews(bytes.NewReader(tiff.data))
+			if nil != err {
+				t.Fatalf("findRawPreviews() failed: %v", err)
+			}
+
+			expected := []rawPreview{
+				{offset: int64(thumbnail), length: 100},
+				{offset: int64(large), length: 1000},
+			}
+			if !slices.Equal(expected, previews) {
+				t.Errorf("findRawPreviews() = %v, expected %v", previews, expected)
+			}
+		})
+	}
+}
+
+func TestFindRawPreviewsWithoutPreviews(t *testing.T) {
+	tiff := newTestTiff(binary.LittleEndian)
+	ifd0, _ := tiff.appendIFD(testIfdEntry{TIFF_TAG_COMPRESSION, TIFF_TYPE_SHORT, 1, 1})
+	tiff.setUint32(4, ifd0)
+
+	if _, err := findRawPreviews(bytes.NewReader(tiff.data)); NoRawPreviewFound != err {
+		t.Errorf("findRawPreviews() error = %v, expected %v", err, NoRawPreviewFound)
+	}
+
+	// IFDs pointing beyond the end of the file are skipped.
+	tiff.setUint32(4, 1<<20)
+	if _, err := findRawPreviews(bytes.NewReader(tiff.data)); NoRawPreviewFound != err {
+		t.Errorf("findRawPreviews() error = %v, expected %v", err, NoRawPreviewFound)
+	}
+
+	if _, err := findRawPreviews(bytes.NewReader([]byte("\x89PNG\r\n\x1a\n"))); nil == err {
+		t.Error("findRawPreviews() succeeded for a non-TIFF file")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"mime"
+	"os/exec"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var videoExtensions = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".3gp":  "video/3gpp",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+}
+
+func init() {
+	extensions := make([]string, 0, len(videoExtensions))
+	for ext, mimeType := range videoExtensions {
+		extensions = append(extensions, ext)
+		// The server may run without a system-wide MIME types database, so make
+		// sure videos are served with the right content type.
+		mime.AddExtensionType(ext, mimeType)
+	}
+
+	registerImageDecoder(&imageDecoder{
+		name:       "video",
+		extensions: extensions,
+		mediaType:  MEDIA_TYPE_VIDEO,
+		// Videos are streamed as they are; only poster frames are decoded.
+		browserSupported: true,
+		// ffmpeg rotates frames according to the video's display matrix.
+		autoOriented: true,
+		decode:       decodeVideoPosterFrame,
+	})
+}
+
+// decodeVideoPosterFrame extracts a poster frame from the video at the given
+// path using ffmpeg. The frame is taken one second into the video, or from its
+// very start for shorter videos.
+func decodeVideoPosterFrame(path string) (image.Image, error) {
+	img, err := extractVideoFrame(path, "1")
+	if nil == err {
+		return img, nil
+	}
+
+	glog.V(1).Infof("Failed to extract frame at 1s from '%s', using first frame: %v", path, err)
+
+	return extractVideoFrame(path, "0")
+}
+
+func extractVideoFrame(path, position string) (image.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, *ffmpegPath,
+		"-v", "error",
+		"-ss", position,
+		"-i", path,
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-c:v", "png",
+		"-")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); nil != err {
+		glog.Errorf("ffmpeg failed for '%s': %v; %s", path, err, stderr.String())
+		return nil, err
+	}
+
+	if stdout.Len() < 1 {
+		return nil, errors.New("no video frame found at position " + position)
+	}
+
+	return png.Decode(&stdout)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	// MAX_IMAGE_UPLOAD_SIZE is the maximum size of images uploaded to search
+	// by, in bytes.
+	MAX_IMAGE_UPLOAD_SIZE = 20 << 20
+	// IMAGE_UPLOAD_JPEG_QUALITY is the quality of uploaded images transcoded
+	// to JPEG.
+	IMAGE_UPLOAD_JPEG_QUALITY = 90
+)
+
+// searchByImage finds the photos most similar to the given image. With a
+// cursor, the image may be omitted as long as its embedding is still cached.
+func (c *serverContext) searchByImage(
+	ctx context.Context,
+	image []byte,
+	limit uint,
+	offset *uint,
+	cursor *string,
+	include []string,
+	filter *models.PhotoFilter,
+) (*models.PhotoResultsResponse, error) {
+	var position *resultsPosition
+	var v []float32
+	if nil != cursor {
+		var err error
+		if position, err = parseResultsCursor(*cursor); nil != err {
+			return nil, err
+		}
+		filter = position.Filter
+		v = c.queryVectors.get(position.VectorHash)
+	}
+
+	if nil == v {
+		if nil == image {
+			if nil != position {
+				// The image vector is no longer cached and can't be recomputed.
+				return nil, InvalidCursor
+			}
+			return nil, InvalidImageUpload
+		}
+
+		image, err := transcodeUploadedImage(image)
+		if nil != err {
+			return nil, err
+		}
+
+		v, err = c.getImageEmbedding(image)
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	hash := c.queryVectors.put(v)
+	if nil != position && hash != position.VectorHash {
+		return nil, InvalidCursor
+	}
+
+	return c.searchPage(ctx, limit, offset, position, include,
+		resultsPosition{VectorHash: hash, Filter: filter},
+		func(params searchParams) ([]*pb.ScoredPoint, error) {
+			glog.V(1).Infof("Search by image filter: %v", params.Filter)
+			return c.store.Search(v, params)
+		})
+}
+
+// transcodeUploadedImage transcodes an uploaded image to JPEG, unless it
+// already is one, since the image embedding service only decodes JPEG.
+func transcodeUploadedImage(image []byte) ([]byte, error) {
+	if "image/jpeg" == http.DetectContentType(image) {
+		return image, nil
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(image), imaging.AutoOrientation(true))
+	if nil != err {
+		glog.V(1).Infof("Failed to decode uploaded image: %v", err)
+		return nil, InvalidImageUpload
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: IMAGE_UPLOAD_JPEG_QUALITY}); nil != err {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// getImageEmbedding gets the embedding of the given JPEG image from the image
+// embedding service, which gets the image as the request body.
+func (c *serverContext) getImageEmbedding(image []byte) ([]float32, error) {
+	req, err := http.NewRequest("POST", c.imageEmbeddingsUrl, bytes.NewReader(image))
+	if nil != err {
+		return nil, err
+	}
+
+	req.Header.Add("content-type", "image/jpeg")
+	req.Header.Add("content-length", strconv.Itoa(len(image)))
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if nil != err {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, EmbeddingServerUnavailable
+		}
+
+		glog.Errorf("Failed to retrieve embedding for image: %v", err)
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if http.StatusNotImplemented == resp.StatusCode {
+		// The embedding server was started without an image model.
+		return nil, ImageSearchNotConfigured
+	} else if http.StatusBadRequest == resp.StatusCode || http.StatusUnsupportedMediaType == resp.StatusCode ||
+		http.StatusRequestEntityTooLarge == resp.StatusCode {
+		glog.V(1).Infof("Image embedding service rejected image with status %d.", resp.StatusCode)
+		return nil, InvalidImageUpload
+	} else if http.StatusOK != resp.StatusCode {
+		glog.Errorf("Image embedding service responded with status %d.", resp.StatusCode)
+		return nil, fmt.Errorf("image embedding service responded with status %d", resp.StatusCode)
+	}
+
+	respBody := &models.EmbeddingResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(respBody); nil != err {
+		glog.Errorf("Failed to decode image embedding response: %v", err)
+		return nil, err
+	}
+
+	if len(respBody.Vector) != VECTOR_SIZE {
+		glog.Errorf("Image embedding has %d dimensions, expected %d.", len(respBody.Vector), VECTOR_SIZE)
+		return nil, fmt.Errorf("image embedding has %d dimensions", len(respBody.Vector))
+	}
+
+	return respBody.Vector, nil
+}
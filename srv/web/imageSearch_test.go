@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestSearchByNonJpegImage(t *testing.T) {
+	embeddings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Like the image embedding service, only accept JPEG images.
+		if _, err := jpeg.Decode(r.Body); nil != err || "image/jpeg" != r.Header.Get("content-type") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(models.EmbeddingResponse{Vector: testVector(0, 1)})
+	}))
+	defer embeddings.Close()
+
+	c := publicServerContext{serverContext: &serverContext{
+		store:              newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db")),
+		imageEmbeddingsUrl: embeddings.URL,
+		queryVectors:       newVectorCache(QUERY_VECTOR_CACHE_SIZE),
+	}}
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		img.Set(x, x, color.RGBA{R: 255, A: 255})
+	}
+	var pngImage, jpegImage bytes.Buffer
+	if err := png.Encode(&pngImage, img); nil != err {
+		t.Fatalf("png.Encode() failed: %v", err)
+	}
+	if err := jpeg.Encode(&jpegImage, img, nil); nil != err {
+		t.Fatalf("jpeg.Encode() failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		image    []byte
+		expected int
+	}{
+		{"png", pngImage.Bytes(), 200},
+		{"jpeg", jpegImage.Bytes(), 200},
+		{"garbage", []byte("not an image"), 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			part, _ := form.CreateFormFile("image", "upload."+test.name)
+			part.Write(test.image)
+			form.Close()
+
+			r := httptest.NewRequest("POST", "/api/v1/photos/search-by-image", &body)
+			r.Header.Set("Content-Type", form.FormDataContentType())
+			w := httptest.NewRecorder()
+			c.handleV1SearchPhotosByImage(w, r)
+
+			if test.expected != w.Code {
+				t.Errorf("search by %s image responded with %d, expected %d: %s",
+					test.name, w.Code, test.expected, w.Body.String())
+			}
+		})
+	}
+}
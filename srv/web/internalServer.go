@@ -42,6 +42,9 @@ func (c internalServerContext) addV1API(mux *mux.Router) {
 	mux.HandleFunc("/index", c.handleV1DeleteFromIndex).
 		Methods("DELETE").
 		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/duplicates", c.handleV1GetExactDuplicates).
+		Methods("GET")
 }
 
 func (c internalServerContext) handleV1GetIndex(w http.ResponseWriter, r *http.Request) {
@@ -88,8 +91,7 @@ func (c internalServerContext) handleV1PostToIndex(w http.ResponseWriter, r *htt
 
 	if err := c.upsert(req.Items); nil != err {
 		glog.Errorf("Failed to insert items: %v", err)
-		w.WriteHeader(500)
-		json.NewEncoder(w).Encode(err)
+		c.respondForError(err, w)
 	} else {
 		glog.V(1).Infof("Successfully upserted %d item(s) ...", len(req.Items))
 		w.WriteHeader(200)
@@ -114,3 +116,32 @@ func (c internalServerContext) handleV1DeleteFromIndex(w http.ResponseWriter, r
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
 	}
 }
+
+func (c internalServerContext) handleV1GetExactDuplicates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	limit := uint(100)
+	if limitStr := query.Get("limit"); "" != limitStr {
+		l, err := strconv.ParseUint(limitStr, 10, 32)
+		if nil != err || l < 1 {
+			c.respondForError(InvalidLimit, w)
+			return
+		}
+		limit = uint(l)
+	}
+
+	var cursor *string
+	if cursorStr := query.Get("cursor"); "" != cursorStr {
+		cursor = &cursorStr
+	}
+
+	res, err := c.listExactDuplicates(r.Context(), limit, cursor)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
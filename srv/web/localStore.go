@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	ALBUMS_BUCKET  = "albums"
+	RATINGS_BUCKET = "ratings"
+	SHARES_BUCKET  = "shares"
+	// SETTINGS_BUCKET holds the state of the server itself, like keys.
+	SETTINGS_BUCKET = "settings"
+
+	// SETTING_SHARE_SIGNING_KEY is the key used to sign share tokens. Unlike
+	// cursors, share tokens must survive restarts, so the key is generated once
+	// and kept in the local database.
+	SETTING_SHARE_SIGNING_KEY = "share_signing_key"
+	SHARE_SIGNING_KEY_SIZE    = 32
+)
+
+// localStore keeps the state curated by users, like albums, ratings and
+// shares, in a local bbolt database. It refers to photos by their ID, which is
+// derived from the photo's path, so the state is not affected by indexing
+// photos again.
+type localStore struct {
+	db *bolt.DB
+
+	shareSigningKey []byte
+}
+
+func openLocalStore(path string) (*localStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); nil != err {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if nil != err {
+		return nil, err
+	}
+
+	s := &localStore{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{ALBUMS_BUCKET, RATINGS_BUCKET, SHARES_BUCKET, SETTINGS_BUCKET} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); nil != err {
+				return err
+			}
+		}
+
+		settings := tx.Bucket([]byte(SETTINGS_BUCKET))
+		if v := settings.Get([]byte(SETTING_SHARE_SIGNING_KEY)); nil != v {
+			s.shareSigningKey = append([]byte(nil), v...)
+			return nil
+		}
+
+		s.shareSigningKey = make([]byte, SHARE_SIGNING_KEY_SIZE)
+		if _, err := rand.Read(s.shareSigningKey); nil != err {
+			return err
+		}
+
+		return settings.Put([]byte(SETTING_SHARE_SIGNING_KEY), s.shareSigningKey)
+	})
+	if nil != err {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *localStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// matchesFilter evaluates a qdrant filter against a point held by the
+// memoryStore, following qdrant's semantics: all 'must' conditions, at least
+// one of the 'should' conditions (if any) and none of the 'must_not'
+// conditions need to be satisfied.
+func matchesFilter(filter *pb.Filter, p *memoryPoint) bool {
+	if nil == filter {
+		return true
+	}
+
+	for _, cond := range filter.Must {
+		if !matchesCondition(cond, p) {
+			return false
+		}
+	}
+
+	for _, cond := range filter.MustNot {
+		if matchesCondition(cond, p) {
+			return false
+		}
+	}
+
+	if len(filter.Should) > 0 {
+		found := false
+		for _, cond := range filter.Should {
+			if matchesCondition(cond, p) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesCondition(cond *pb.Condition, p *memoryPoint) bool {
+	switch c := cond.ConditionOneOf.(type) {
+	case *pb.Condition_Field:
+		return matchesFieldCondition(c.Field, getPayloadValues(p.payload, c.Field.Key))
+
+	case *pb.Condition_Filter:
+		return matchesFilter(c.Filter, p)
+
+	case *pb.Condition_HasId:
+		return slices.ContainsFunc(c.HasId.HasId, func(id *pb.PointId) bool {
+			return id.GetUuid() == p.id
+		})
+
+	case *pb.Condition_IsEmpty:
+		return len(getPayloadValues(p.payload, c.IsEmpty.Key)) < 1
+
+	case *pb.Condition_IsNull:
+		values := getPayloadValues(p.payload, c.IsNull.Key)
+		return len(values) == 1 && isNullValue(values[0])
+
+	default:
+		glog.Warningf("Unsupported filter condition: %v", cond)
+		return false
+	}
+}
+
+// matchesFieldCondition checks if any of the values satisfies the condition.
+func matchesFieldCondition(cond *pb.FieldCondition, values []*pb.Value) bool {
+	for _, value := range values {
+		if nil != cond.Range && !matchesRange(cond.Range, value) {
+			continue
+		}
+
+		if nil != cond.Match && !matchesMatch(cond.Match, value) {
+			continue
+		}
+
+		if nil != cond.GeoBoundingBox && !matchesGeoBoundingBox(cond.GeoBoundingBox, value) {
+			continue
+		}
+
+		if nil != cond.GeoRadius && !matchesGeoRadius(cond.GeoRadius, value) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func matchesRange(r *pb.Range, value *pb.Value) bool {
+	f, ok := getNumericValue(value)
+	if !ok {
+		return false
+	}
+
+	return (nil == r.Lt || f < *r.Lt) &&
+		(nil == r.Lte || f <= *r.Lte) &&
+		(nil == r.Gt || f > *r.Gt) &&
+		(nil == r.Gte || f >= *r.Gte)
+}
+
+func matchesGeoBoundingBox(bbox *pb.GeoBoundingBox, value *pb.Value) bool {
+	lat, lon, ok := getGeoPointFromValue(value)
+	return ok && isInGeoBoundingBox(lat, lon, bbox)
+}
+
+func matchesGeoRadius(radius *pb.GeoRadius, value *pb.Value) bool {
+	lat, lon, ok := getGeoPointFromValue(value)
+	if !ok {
+		return false
+	}
+
+	center := radius.GetCenter()
+	return geoDistance(center.GetLat(), center.GetLon(), lat, lon) <= float64(radius.GetRadius())
+}
+
+func matchesMatch(m *pb.Match, value *pb.Value) bool {
+	switch match := m.MatchValue.(type) {
+	case *pb.Match_Keyword:
+		s, ok := value.Kind.(*pb.Value_StringValue)
+		return ok && s.StringValue == match.Keyword
+
+	case *pb.Match_Keywords:
+		s, ok := value.Kind.(*pb.Value_StringValue)
+		return ok && slices.Contains(match.Keywords.Strings, s.StringValue)
+
+	case *pb.Match_ExceptKeywords:
+		s, ok := value.Kind.(*pb.Value_StringValue)
+		return ok && !slices.Contains(match.ExceptKeywords.Strings, s.StringValue)
+
+	case *pb.Match_Integer:
+		i, ok := value.Kind.(*pb.Value_IntegerValue)
+		return ok && i.IntegerValue == match.Integer
+
+	case *pb.Match_Integers:
+		i, ok := value.Kind.(*pb.Value_IntegerValue)
+		return ok && slices.Contains(match.Integers.Integers, i.IntegerValue)
+
+	case *pb.Match_ExceptIntegers:
+		i, ok := value.Kind.(*pb.Value_IntegerValue)
+		return ok && !slices.Contains(match.ExceptIntegers.Integers, i.IntegerValue)
+
+	case *pb.Match_Boolean:
+		b, ok := value.Kind.(*pb.Value_BoolValue)
+		return ok && b.BoolValue == match.Boolean
+
+	case *pb.Match_Text:
+		s, ok := value.Kind.(*pb.Value_StringValue)
+		return ok && strings.Contains(
+			strings.ToLower(s.StringValue), strings.ToLower(match.Text))
+
+	default:
+		glog.Warningf("Unsupported match condition: %v", m)
+		return false
+	}
+}
+
+// getPayloadValues resolves a (possibly nested) payload key like 'exif.Make'
+// to the values found at that key. Lists are flattened, such that a condition
+// is satisfied if any of the list's elements satisfies it.
+func getPayloadValues(payload map[string]*pb.Value, key string) []*pb.Value {
+	name, rest, nested := strings.Cut(key, ".")
+	name = strings.TrimSuffix(name, "[]")
+
+	value, found := payload[name]
+	if !found {
+		return nil
+	}
+
+	var values []*pb.Value
+	if list, ok := value.Kind.(*pb.Value_ListValue); ok {
+		values = list.ListValue.GetValues()
+	} else {
+		values = []*pb.Value{value}
+	}
+
+	if !nested {
+		return values
+	}
+
+	var result []*pb.Value
+	for _, v := range values {
+		if s, ok := v.Kind.(*pb.Value_StructValue); ok {
+			result = append(result, getPayloadValues(s.StructValue.GetFields(), rest)...)
+		}
+	}
+
+	return result
+}
+
+func getNumericValue(value *pb.Value) (float64, bool) {
+	switch v := value.Kind.(type) {
+	case *pb.Value_IntegerValue:
+		return float64(v.IntegerValue), true
+
+	case *pb.Value_DoubleValue:
+		return v.DoubleValue, true
+
+	default:
+		return 0, false
+	}
+}
+
+func isNullValue(value *pb.Value) bool {
+	_, ok := value.Kind.(*pb.Value_NullValue)
+	return ok
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+func TestMatchesFilter(t *testing.T) {
+	p := &memoryPoint{
+		id: "00000000-0000-0000-0000-000000000001",
+		payload: pb.NewValueMap(map[string]any{
+			"path":      "2024/summer/beach.jpg",
+			"timestamp": 1700000000,
+			"tags":      []any{"beach", "family"},
+			"exif": map[string]any{
+				"Make":    "Canon",
+				"FNumber": 2.8,
+			},
+			"nothing": nil,
+		}),
+	}
+
+	tests := []struct {
+		name     string
+		filter   *pb.Filter
+		expected bool
+	}{
+		{"nil filter", nil, true},
+		{"empty filter", &pb.Filter{}, true},
+		{"keyword", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchKeyword("path", "2024/summer/beach.jpg")}}, true},
+		{"keyword mismatch", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchKeyword("path", "beach.jpg")}}, false},
+		{"keywords", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchKeywords("tags", "city", "family")}}, true},
+		{"keywords in list", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchKeyword("tags[]", "beach")}}, true},
+		{"except keywords", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchExceptKeywords("exif.Make", "Nikon", "Sony")}}, true},
+		{"text", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchText("path", "SUMMER")}}, true},
+		{"integer", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchInt("timestamp", 1700000000)}}, true},
+		{"missing field", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchKeyword("album", "x")}}, false},
+		{"range", &pb.Filter{Must: []*pb.Condition{
+			pb.NewRange("timestamp", &pb.Range{Gte: ptr(1600000000.0), Lt: ptr(1800000000.0)})}}, true},
+		{"range exclusive bound", &pb.Filter{Must: []*pb.Condition{
+			pb.NewRange("timestamp", &pb.Range{Gt: ptr(1700000000.0)})}}, false},
+		{"range on non-numeric", &pb.Filter{Must: []*pb.Condition{
+			pb.NewRange("path", &pb.Range{Gte: ptr(0.0)})}}, false},
+		{"nested keyword", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchKeyword("exif.Make", "Canon")}}, true},
+		{"nested range", &pb.Filter{Must: []*pb.Condition{
+			pb.NewRange("exif.FNumber", &pb.Range{Lte: ptr(2.8)})}}, true},
+		{"nested missing", &pb.Filter{Must: []*pb.Condition{
+			pb.NewMatchKeyword("exif.Model", "EOS")}}, false},
+		{"must not", &pb.Filter{MustNot: []*pb.Condition{
+			pb.NewMatchKeyword("exif.Make", "Canon")}}, false},
+		{"must not mismatch", &pb.Filter{MustNot: []*pb.Condition{
+			pb.NewMatchKeyword("exif.Make", "Nikon")}}, true},
+		{"should any", &pb.Filter{Should: []*pb.Condition{
+			pb.NewMatchKeyword("exif.Make", "Nikon"),
+			pb.NewMatchKeyword("tags", "family")}}, true},
+		{"should none", &pb.Filter{Should: []*pb.Condition{
+			pb.NewMatchKeyword("exif.Make", "Nikon"),
+			pb.NewMatchKeyword("tags", "city")}}, false},
+		{"has id", &pb.Filter{Must: []*pb.Condition{
+			pb.NewHasID(pb.NewID("00000000-0000-0000-0000-000000000002"), pb.NewID(p.id))}}, true},
+		{"has id mismatch", &pb.Filter{Must: []*pb.Condition{
+			pb.NewHasID(pb.NewID("00000000-0000-0000-0000-000000000002"))}}, false},
+		{"is empty", &pb.Filter{Must: []*pb.Condition{pb.NewIsEmpty("album")}}, true},
+		{"is empty on set field", &pb.Filter{Must: []*pb.Condition{pb.NewIsEmpty("tags")}}, false},
+		{"is null", &pb.Filter{Must: []*pb.Condition{pb.NewIsNull("nothing")}}, true},
+		{"nested filter", &pb.Filter{Must: []*pb.Condition{
+			pb.NewFilterAsCondition(&pb.Filter{MustNot: []*pb.Condition{
+				pb.NewMatchKeyword("tags", "city")}})}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := matchesFilter(test.filter, p); test.expected != actual {
+				t.Errorf("matchesFilter() = %t, expected %t", actual, test.expected)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
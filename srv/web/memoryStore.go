@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// memoryStore is a VectorStore that keeps all points in memory and finds
+// similar points by brute force. It persists the points to a local file as
+// an append-only log of (length-delimited) point structs, where a point
+// without vectors marks the deletion of that point. The log is compacted
+// every time the store is loaded.
+type memoryStore struct {
+	path string
+
+	mutex  sync.RWMutex
+	points map[string]*memoryPoint
+	log    *os.File
+}
+
+type memoryPoint struct {
+	id string
+	// vector is normalized to unit length, so the dot product of two vectors
+	// is their cosine similarity.
+	vector  []float32
+	payload map[string]*pb.Value
+}
+
+type scoredMemoryPoint struct {
+	point *memoryPoint
+	score float32
+}
+
+func newMemoryStore(path string) *memoryStore {
+	return &memoryStore{
+		path:   path,
+		points: make(map[string]*memoryPoint),
+	}
+}
+
+func (s *memoryStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if nil == s.log {
+		return nil
+	}
+
+	err := s.log.Close()
+	s.log = nil
+
+	return err
+}
+
+func (s *memoryStore) EnsureCollection() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); nil != err {
+		glog.Errorf("Failed to create directory for store file '%s': %v", s.path, err)
+		return err
+	}
+
+	if err := s.load(); nil != err {
+		glog.Errorf("Failed to load store file '%s': %v", s.path, err)
+		return err
+	}
+
+	if err := s.compact(); nil != err {
+		glog.Errorf("Failed to compact store file '%s': %v", s.path, err)
+		return err
+	}
+
+	glog.Infof("Loaded %d point(s) from store file '%s'.", len(s.points), s.path)
+
+	return nil
+}
+
+func (s *memoryStore) load() error {
+	file, err := os.Open(s.path)
+	if nil != err {
+		if errors.Is(err, os.ErrNotExist) {
+			glog.V(1).Infof("Store file '%s' does not exist, starting empty ...", s.path)
+			return nil
+		}
+
+		return err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		point := &pb.PointStruct{}
+		err := protodelim.UnmarshalFrom(reader, point)
+		if nil != err {
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if errors.Is(err, io.ErrUnexpectedEOF) {
+				// The last record was only partially written.
+				glog.Warningf("Ignoring truncated record at end of store file '%s'.", s.path)
+				return nil
+			}
+
+			return err
+		}
+
+		s.apply(point)
+	}
+}
+
+// compact rewrites the log with just the current points and keeps the new
+// log open for appending.
+func (s *memoryStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if nil != err {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, p := range s.points {
+		if _, err := protodelim.MarshalTo(writer, p.toPointStruct()); nil != err {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); nil != err {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); nil != err {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); nil != err {
+		return err
+	}
+
+	s.log, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+
+	return err
+}
+
+// apply applies a record from the log to the in-memory points.
+func (s *memoryStore) apply(point *pb.PointStruct) {
+	id := point.Id.GetUuid()
+	vector := point.Vectors.GetVector().GetData()
+
+	if nil == vector {
+		delete(s.points, id)
+	} else {
+		s.points[id] = &memoryPoint{
+			id:      id,
+			vector:  vector,
+			payload: point.Payload,
+		}
+	}
+}
+
+func (s *memoryStore) append(records []*pb.PointStruct) error {
+	if nil == s.log {
+		return errors.New("store is not open")
+	}
+
+	writer := bufio.NewWriter(s.log)
+	for _, record := range records {
+		if _, err := protodelim.MarshalTo(writer, record); nil != err {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); nil != err {
+		return err
+	}
+
+	return s.log.Sync()
+}
+
+func (s *memoryStore) Scroll(ctx context.Context, params scrollParams) ([]*pb.RetrievedPoint, *string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if nil != params.OrderBy {
+		return s.scrollOrdered(params), nil, nil
+	}
+
+	ids := make([]string, 0, len(s.points))
+	for id, p := range s.points {
+		if (nil == params.Offset || id >= *params.Offset) && matchesFilter(params.Filter, p) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var nextOffset *string
+	if len(ids) > int(params.PageSize) {
+		nextOffset = &ids[params.PageSize]
+		ids = ids[:params.PageSize]
+	}
+
+	result := make([]*pb.RetrievedPoint, len(ids))
+	for i, id := range ids {
+		p := s.points[id]
+		result[i] = &pb.RetrievedPoint{
+			Id:      makePointId(p.id),
+			Payload: p.selectPayload(params.Fields),
+		}
+	}
+
+	return result, nextOffset, nil
+}
+
+// scrollOrdered gets the first page of points ordered by a payload field.
+func (s *memoryStore) scrollOrdered(params scrollParams) []*pb.RetrievedPoint {
+	type orderedPoint struct {
+		point *memoryPoint
+		value float64
+	}
+
+	desc := pb.Direction_Desc == params.OrderBy.GetDirection()
+	var start *float64
+	switch from := params.OrderBy.GetStartFrom().GetValue().(type) {
+	case *pb.StartFrom_Integer:
+		f := float64(from.Integer)
+		start = &f
+	case *pb.StartFrom_Float:
+		start = &from.Float
+	}
+
+	var candidates []orderedPoint
+	for _, p := range s.points {
+		values := getPayloadValues(p.payload, params.OrderBy.Key)
+		if len(values) < 1 || !matchesFilter(params.Filter, p) {
+			continue
+		}
+
+		value, ok := getNumericValue(values[0])
+		if !ok {
+			continue
+		} else if nil != start && ((desc && value > *start) || (!desc && value < *start)) {
+			continue
+		}
+
+		candidates = append(candidates, orderedPoint{point: p, value: value})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].value != candidates[j].value {
+			return desc == (candidates[i].value > candidates[j].value)
+		}
+		return candidates[i].point.id < candidates[j].point.id
+	})
+
+	if len(candidates) > int(params.PageSize) {
+		candidates = candidates[:params.PageSize]
+	}
+
+	result := make([]*pb.RetrievedPoint, len(candidates))
+	for i, c := range candidates {
+		result[i] = &pb.RetrievedPoint{
+			Id:      makePointId(c.point.id),
+			Payload: c.point.selectPayload(params.Fields),
+		}
+	}
+
+	return result
+}
+
+func (s *memoryStore) Upsert(points []*pb.PointStruct) error {
+	records := make([]*pb.PointStruct, len(points))
+	for i, point := range points {
+		vector := point.Vectors.GetVector().GetData()
+		if len(vector) != VECTOR_SIZE {
+			return fmt.Errorf("vector for point '%s' has dimension %d, expected %d",
+				point.Id.GetUuid(), len(vector), VECTOR_SIZE)
+		}
+
+		records[i] = &pb.PointStruct{
+			Id:      point.Id,
+			Payload: point.Payload,
+			Vectors: &pb.Vectors{
+				VectorsOptions: &pb.Vectors_Vector{
+					Vector: &pb.Vector{Data: normalize(vector)},
+				},
+			},
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.append(records); nil != err {
+		glog.Errorf("Failed to persist upserted points: %v", err)
+		return err
+	}
+
+	for _, record := range records {
+		s.apply(record)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Delete(ids []string) error {
+	records := make([]*pb.PointStruct, len(ids))
+	for i, id := range ids {
+		records[i] = &pb.PointStruct{Id: makePointId(id)}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.append(records); nil != err {
+		glog.Errorf("Failed to persist deleted points: %v", err)
+		return err
+	}
+
+	for _, record := range records {
+		s.apply(record)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) SetPayload(ids []string, payload map[string]*pb.Value) error {
+	return s.updatePayloads(ids, func(p map[string]*pb.Value) {
+		for key, value := range payload {
+			p[key] = value
+		}
+	})
+}
+
+func (s *memoryStore) DeletePayload(ids []string, keys []string) error {
+	return s.updatePayloads(ids, func(p map[string]*pb.Value) {
+		for _, key := range keys {
+			delete(p, key)
+		}
+	})
+}
+
+// updatePayloads updates the payloads of the existing points with the given
+// IDs, and persists the updated points.
+func (s *memoryStore) updatePayloads(ids []string, update func(map[string]*pb.Value)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make([]*pb.PointStruct, 0, len(ids))
+	for _, id := range ids {
+		p, found := s.points[id]
+		if !found {
+			continue
+		}
+
+		updated := &memoryPoint{id: id, vector: p.vector, payload: p.copyPayload()}
+		update(updated.payload)
+		records = append(records, updated.toPointStruct())
+	}
+
+	if err := s.append(records); nil != err {
+		glog.Errorf("Failed to persist updated payloads: %v", err)
+		return err
+	}
+
+	for _, record := range records {
+		s.apply(record)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetPayload(id string) (map[string]*pb.Value, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	p, found := s.points[id]
+	if !found {
+		return nil, PhotoNotFound
+	}
+
+	return p.copyPayload(), nil
+}
+
+func (s *memoryStore) GetPoints(ctx context.Context, ids []string, fields []string) ([]*pb.RetrievedPoint, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]*pb.RetrievedPoint, 0, len(ids))
+	for _, id := range ids {
+		if p, found := s.points[id]; found {
+			result = append(result, &pb.RetrievedPoint{
+				Id:      makePointId(p.id),
+				Payload: p.selectPayload(fields),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func (s *memoryStore) Search(vector []float32, params searchParams) ([]*pb.ScoredPoint, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	vector = normalize(vector)
+	return s.searchNearest(func(p *memoryPoint) float32 {
+		return dot(vector, p.vector)
+	}, nil, params), nil
+}
+
+// Recommend follows qdrant's recommendation strategies: 'average vector'
+// searches the points closest to the average of the positive examples, moved
+// away from the average of the negative examples, while 'best score' scores
+// each point by its similarity to the closest positive example, unless it is
+// closer to a negative example.
+func (s *memoryStore) Recommend(examples recommendExamples, params searchParams) ([]*pb.ScoredPoint, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.recommend(examples, params)
+}
+
+func (s *memoryStore) RecommendBatch(examples []recommendExamples, params searchParams) ([][]*pb.ScoredPoint, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	results := make([][]*pb.ScoredPoint, len(examples))
+	for i, e := range examples {
+		result, err := s.recommend(e, params)
+		if nil != err {
+			return nil, err
+		}
+
+		for _, point := range result {
+			point.Payload = nil
+		}
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+func (s *memoryStore) recommend(examples recommendExamples, params searchParams) ([]*pb.ScoredPoint, error) {
+	positive, err := s.getExampleVectors(examples.Positive, examples.PositiveVectors)
+	if nil != err {
+		return nil, err
+	}
+	negative, err := s.getExampleVectors(examples.Negative, examples.NegativeVectors)
+	if nil != err {
+		return nil, err
+	}
+	exclude := append(slices.Clone(examples.Positive), examples.Negative...)
+
+	if pb.RecommendStrategy_BestScore == examples.Strategy {
+		return s.searchNearest(func(p *memoryPoint) float32 {
+			return bestScore(p.vector, positive, negative)
+		}, exclude, params), nil
+	}
+
+	vector := average(positive)
+	if len(negative) > 0 {
+		avgNegative := average(negative)
+		for i := range vector {
+			vector[i] += vector[i] - avgNegative[i]
+		}
+	}
+	vector = normalize(vector)
+
+	return s.searchNearest(func(p *memoryPoint) float32 {
+		return dot(vector, p.vector)
+	}, exclude, params), nil
+}
+
+// getExampleVectors gets the normalized vectors of the example points with the
+// given IDs, along with the given example vectors.
+func (s *memoryStore) getExampleVectors(ids []string, vectors [][]float32) ([][]float32, error) {
+	result := make([][]float32, 0, len(ids)+len(vectors))
+	for _, id := range ids {
+		p, found := s.points[id]
+		if !found {
+			return nil, PhotoNotFound
+		}
+		result = append(result, p.vector)
+	}
+
+	for _, v := range vectors {
+		if len(v) != VECTOR_SIZE {
+			return nil, fmt.Errorf("example vector has %d dimensions, expected %d",
+				len(v), VECTOR_SIZE)
+		}
+		result = append(result, normalize(v))
+	}
+
+	return result, nil
+}
+
+// searchNearest finds the points with the highest scores, skipping the points
+// with the given IDs.
+func (s *memoryStore) searchNearest(
+	scorer func(p *memoryPoint) float32,
+	exclude []string,
+	params searchParams,
+) []*pb.ScoredPoint {
+	var candidates []scoredMemoryPoint
+	for _, p := range s.points {
+		if slices.Contains(exclude, p.id) || !matchesFilter(params.Filter, p) {
+			continue
+		}
+
+		score := scorer(p)
+		if nil != params.ScoreThreshold && score < *params.ScoreThreshold {
+			continue
+		}
+
+		candidates = append(candidates, scoredMemoryPoint{point: p, score: score})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return strings.Compare(candidates[i].point.id, candidates[j].point.id) < 0
+	})
+
+	if params.Offset >= uint64(len(candidates)) {
+		return []*pb.ScoredPoint{}
+	}
+	candidates = candidates[params.Offset:]
+	if uint64(len(candidates)) > params.Limit {
+		candidates = candidates[:params.Limit]
+	}
+
+	result := make([]*pb.ScoredPoint, len(candidates))
+	for i, c := range candidates {
+		result[i] = &pb.ScoredPoint{
+			Id:      makePointId(c.point.id),
+			Payload: c.point.copyPayload(),
+			Score:   c.score,
+		}
+	}
+
+	return result
+}
+
+func (p *memoryPoint) toPointStruct() *pb.PointStruct {
+	return &pb.PointStruct{
+		Id:      makePointId(p.id),
+		Payload: p.payload,
+		Vectors: &pb.Vectors{
+			VectorsOptions: &pb.Vectors_Vector{
+				Vector: &pb.Vector{Data: p.vector},
+			},
+		},
+	}
+}
+
+func (p *memoryPoint) copyPayload() map[string]*pb.Value {
+	payload := make(map[string]*pb.Value, len(p.payload))
+	for k, v := range p.payload {
+		payload[k] = v
+	}
+
+	return payload
+}
+
+// selectPayload copies the top-level payload fields required for the given
+// (possibly nested) fields; if no fields are given, the full payload is copied.
+func (p *memoryPoint) selectPayload(fields []string) map[string]*pb.Value {
+	if len(fields) < 1 {
+		return p.copyPayload()
+	}
+
+	payload := make(map[string]*pb.Value, len(fields))
+	for _, field := range fields {
+		name, _, _ := strings.Cut(field, ".")
+		if v, found := p.payload[name]; found {
+			payload[name] = v
+		}
+	}
+
+	return payload
+}
+
+func normalize(v []float32) []float32 {
+	norm := math.Sqrt(float64(dot(v, v)))
+	result := make([]float32, len(v))
+	if 0 == norm {
+		return result
+	}
+
+	for i, x := range v {
+		result[i] = float32(float64(x) / norm)
+	}
+
+	return result
+}
+
+func average(vectors [][]float32) []float32 {
+	result := make([]float32, VECTOR_SIZE)
+	if len(vectors) < 1 {
+		return result
+	}
+
+	for _, v := range vectors {
+		for i, x := range v {
+			result[i] += x
+		}
+	}
+	for i := range result {
+		result[i] /= float32(len(vectors))
+	}
+
+	return result
+}
+
+// bestScore scores a vector by its similarity to the closest positive vector,
+// or negatively by its similarity to the closest negative vector if that is
+// closer, like qdrant's 'best score' recommendation strategy.
+func bestScore(v []float32, positive, negative [][]float32) float32 {
+	bestPositive := float32(math.Inf(-1))
+	for _, p := range positive {
+		bestPositive = max(bestPositive, dot(v, p))
+	}
+
+	bestNegative := float32(math.Inf(-1))
+	for _, n := range negative {
+		bestNegative = max(bestNegative, dot(v, n))
+	}
+
+	if bestPositive > bestNegative {
+		return bestPositive
+	}
+
+	return -(bestNegative * bestNegative)
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
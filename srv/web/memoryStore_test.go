@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+func newTestMemoryStore(t *testing.T, path string) *memoryStore {
+	t.Helper()
+
+	store := newMemoryStore(path)
+	if err := store.EnsureCollection(); nil != err {
+		t.Fatalf("EnsureCollection() failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func testPointId(i int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+}
+
+// testVector makes a vector pointing mostly along the given axis.
+func testVector(axis int, weight float32) []float32 {
+	v := make([]float32, VECTOR_SIZE)
+	v[axis] = weight
+	v[VECTOR_SIZE-1] = 1 - weight
+
+	return v
+}
+
+func testPoint(id string, vector []float32, payload map[string]any) *pb.PointStruct {
+	return &pb.PointStruct{
+		Id:      makePointId(id),
+		Payload: pb.NewValueMap(payload),
+		Vectors: &pb.Vectors{
+			VectorsOptions: &pb.Vectors_Vector{Vector: &pb.Vector{Data: vector}},
+		},
+	}
+}
+
+func getIds[P interface{ GetId() *pb.PointId }](points []P) []string {
+	ids := make([]string, len(points))
+	for i, p := range points {
+		ids[i] = p.GetId().GetUuid()
+	}
+
+	return ids
+}
+
+func TestMemoryStoreSearch(t *testing.T) {
+	store := newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db"))
+	err := store.Upsert([]*pb.PointStruct{
+		testPoint(testPointId(1), testVector(0, 0.5), map[string]any{"path": "a.jpg"}),
+		testPoint(testPointId(2), testVector(0, 0.9), map[string]any{"path": "b.jpg"}),
+		testPoint(testPointId(3), testVector(0, 0.7), map[string]any{"path": "c.jpg"}),
+		testPoint(testPointId(4), testVector(1, 1), map[string]any{"path": "d.jpg"}),
+		// Ties are broken by ID.
+		testPoint(testPointId(0), testVector(0, 0.7), map[string]any{"path": "e.jpg"}),
+	})
+	if nil != err {
+		t.Fatalf("Upsert() failed: %v", err)
+	}
+
+	query := testVector(0, 1)
+	tests := []struct {
+		name     string
+		params   searchParams
+		expected []string
+	}{
+		{"all", searchParams{Limit: 10},
+			[]string{testPointId(2), testPointId(0), testPointId(3), testPointId(1), testPointId(4)}},
+		{"limit", searchParams{Limit: 2},
+			[]string{testPointId(2), testPointId(0)}},
+		{"offset", searchParams{Limit: 2, Offset: 2},
+			[]string{testPointId(3), testPointId(1)}},
+		{"offset beyond end", searchParams{Limit: 2, Offset: 10}, []string{}},
+		{"score threshold", searchParams{Limit: 10, ScoreThreshold: ptr(float32(0.8))},
+			[]string{testPointId(2), testPointId(0), testPointId(3)}},
+		{"filter", searchParams{Limit: 10, Filter: &pb.Filter{MustNot: []*pb.Condition{
+			pb.NewMatchKeywords("path", "b.jpg", "e.jpg")}}},
+			[]string{testPointId(3), testPointId(1), testPointId(4)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points, err := store.Search(query, test.params)
+			if nil != err {
+				t.Fatalf("Search() failed: %v", err)
+			}
+
+			if actual := getIds(points); !slices.Equal(test.expected, actual) {
+				t.Errorf("Search() = %v, expected %v", actual, test.expected)
+			}
+			for i := 1; i < len(points); i++ {
+				if points[i-1].Score < points[i].Score {
+					t.Errorf("Search() scores are not descending: %v", points)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreScroll(t *testing.T) {
+	store := newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db"))
+	var points []*pb.PointStruct
+	for _, i := range []int{3, 1, 4, 5, 2} {
+		points = append(points, testPoint(testPointId(i), testVector(i, 1),
+			map[string]any{"timestamp": 10 * i, "exif": map[string]any{"Make": "Canon"}}))
+	}
+	if err := store.Upsert(points); nil != err {
+		t.Fatalf("Upsert() failed: %v", err)
+	}
+
+	var pages [][]string
+	params := scrollParams{PageSize: 2, Fields: []string{"exif.Make"}}
+	for {
+		page, next, err := store.Scroll(context.Background(), params)
+		if nil != err {
+			t.Fatalf("Scroll() failed: %v", err)
+		}
+
+		pages = append(pages, getIds(page))
+		for _, p := range page {
+			if _, found := p.Payload["timestamp"]; found || nil == p.Payload["exif"] {
+				t.Errorf("Scroll() payload = %v, expected only 'exif'", p.Payload)
+			}
+		}
+
+		if nil == next {
+			break
+		}
+		params.Offset = next
+	}
+
+	expected := [][]string{
+		{testPointId(1), testPointId(2)},
+		{testPointId(3), testPointId(4)},
+		{testPointId(5)},
+	}
+	if !slices.EqualFunc(expected, pages, slices.Equal) {
+		t.Errorf("Scroll() pages = %v, expected %v", pages, expected)
+	}
+
+	ordered, next, err := store.Scroll(context.Background(), scrollParams{
+		PageSize: 3,
+		OrderBy: &pb.OrderBy{
+			Key:       "timestamp",
+			Direction: pb.Direction_Desc.Enum(),
+			StartFrom: pb.NewStartFromInt(40),
+		},
+	})
+	if nil != err {
+		t.Fatalf("Scroll() failed: %v", err)
+	}
+	expectedOrdered := []string{testPointId(4), testPointId(3), testPointId(2)}
+	if actual := getIds(ordered); !slices.Equal(expectedOrdered, actual) || nil != next {
+		t.Errorf("ordered Scroll() = %v, %v, expected %v, nil", actual, next, expectedOrdered)
+	}
+}
+
+func TestMemoryStoreSetPayload(t *testing.T) {
+	store := newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db"))
+	id := testPointId(1)
+	err := store.Upsert([]*pb.PointStruct{
+		testPoint(id, testVector(0, 1), map[string]any{"path": "a.jpg", "album": "x"}),
+	})
+	if nil != err {
+		t.Fatalf("Upsert() failed: %v", err)
+	}
+
+	// Later updates win over earlier ones, and unknown IDs are ignored.
+	steps := []func() error{
+		func() error {
+			return store.SetPayload([]string{id, testPointId(2)}, pb.NewValueMap(map[string]any{"album": "y", "rating": 3}))
+		},
+		func() error {
+			return store.SetPayload([]string{id}, pb.NewValueMap(map[string]any{"album": "z"}))
+		},
+		func() error { return store.DeletePayload([]string{id}, []string{"rating"}) },
+	}
+	for _, step := range steps {
+		if err := step(); nil != err {
+			t.Fatalf("updating payload failed: %v", err)
+		}
+	}
+
+	payload, err := store.GetPayload(id)
+	if nil != err {
+		t.Fatalf("GetPayload() failed: %v", err)
+	}
+	if "z" != payload["album"].GetStringValue() || "a.jpg" != payload["path"].GetStringValue() {
+		t.Errorf("GetPayload() = %v, expected album 'z' and path 'a.jpg'", payload)
+	}
+	if _, found := payload["rating"]; found {
+		t.Errorf("GetPayload() = %v, expected no rating", payload)
+	}
+	if _, err := store.GetPayload(testPointId(2)); PhotoNotFound != err {
+		t.Errorf("GetPayload() of unknown point returned %v, expected %v", err, PhotoNotFound)
+	}
+}
+
+func TestMemoryStoreGetPoints(t *testing.T) {
+	store := newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db"))
+	err := store.Upsert([]*pb.PointStruct{
+		testPoint(testPointId(1), testVector(0, 1), map[string]any{"path": "a.jpg", "tags": "x"}),
+		testPoint(testPointId(2), testVector(1, 1), map[string]any{"path": "b.jpg"}),
+	})
+	if nil != err {
+		t.Fatalf("Upsert() failed: %v", err)
+	}
+
+	// Unknown IDs are skipped, and only the requested fields are included.
+	points, err := store.GetPoints(context.Background(),
+		[]string{testPointId(2), testPointId(3), testPointId(1)}, []string{"tags"})
+	if nil != err {
+		t.Fatalf("GetPoints() failed: %v", err)
+	}
+	if ids := getIds(points); !slices.Equal([]string{testPointId(2), testPointId(1)}, ids) {
+		t.Errorf("GetPoints() = %v, expected points 2 and 1", ids)
+	}
+	for _, point := range points {
+		if _, found := point.Payload["path"]; found {
+			t.Errorf("GetPoints() payload = %v, expected no path", point.Payload)
+		}
+	}
+	if "x" != points[1].Payload["tags"].GetStringValue() {
+		t.Errorf("GetPoints() payload = %v, expected tags 'x'", points[1].Payload)
+	}
+}
+
+func TestMemoryStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photos.db")
+	store := newMemoryStore(path)
+	if err := store.EnsureCollection(); nil != err {
+		t.Fatalf("EnsureCollection() failed: %v", err)
+	}
+
+	err := store.Upsert([]*pb.PointStruct{
+		testPoint(testPointId(1), testVector(0, 1), map[string]any{"path": "a.jpg"}),
+		testPoint(testPointId(2), testVector(1, 1), map[string]any{"path": "b.jpg"}),
+		testPoint(testPointId(3), testVector(2, 1), map[string]any{"path": "c.jpg"}),
+	})
+	if nil == err {
+		err = store.SetPayload([]string{testPointId(1)}, pb.NewValueMap(map[string]any{"tags": []any{"x"}}))
+	}
+	if nil == err {
+		err = store.Delete([]string{testPointId(2)})
+	}
+	if nil != err {
+		t.Fatalf("updating store failed: %v", err)
+	}
+	store.Close()
+
+	// A partially written record at the end of the log is ignored.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if nil != err {
+		t.Fatalf("opening store file failed: %v", err)
+	}
+	f.Write([]byte{0x80, 0x10, 0x0a})
+	f.Close()
+
+	for range 2 {
+		store = newTestMemoryStore(t, path)
+
+		points, _, err := store.Scroll(context.Background(), scrollParams{PageSize: 10})
+		if nil != err {
+			t.Fatalf("Scroll() failed: %v", err)
+		}
+		expected := []string{testPointId(1), testPointId(3)}
+		if actual := getIds(points); !slices.Equal(expected, actual) {
+			t.Errorf("reloaded points = %v, expected %v", actual, expected)
+		}
+		if tags := getTagsFromPayload(points[0].Payload); !slices.Equal([]string{"x"}, tags) {
+			t.Errorf("reloaded tags = %v, expected [x]", tags)
+		}
+
+		results, err := store.Search(testVector(2, 1), searchParams{Limit: 1})
+		if nil != err || 1 != len(results) || testPointId(3) != results[0].Id.GetUuid() {
+			t.Errorf("Search() after reload = %v, %v, expected %s", results, err, testPointId(3))
+		}
+
+		store.Close()
+	}
+}
@@ -13,6 +13,12 @@ type ItemPayload struct {
 	Path      string         `json:"path"`
 	Timestamp *int64         `json:"timestamp"`
 	Exif      map[string]any `json:"exif"`
+	// MediaType is either 'photo' or 'video'; if empty, it is 'photo', and if
+	// not set, it is derived from the file extension.
+	MediaType *string `json:"media_type,omitempty"`
+	// ContentHash is the hex encoded SHA-256 hash of the file's content; if
+	// not set, it is computed from the file if the photos directory is set.
+	ContentHash *string `json:"content_hash,omitempty"`
 }
 
 type DeleteFromIndexRequest struct {
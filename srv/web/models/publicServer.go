@@ -3,20 +3,71 @@ package models
 type PhotosRequestBase struct {
 	Limit  *uint `json:"limit,omitempty"`
 	Offset *uint `json:"offset,omitempty"`
+	// Cursor is the cursor returned with the previous page, if any. It takes
+	// precedence over the offset and filter.
+	Cursor *string `json:"cursor,omitempty"`
+	// Include lists the optional metadata to include with the results; any of
+	// 'score', 'dimensions', 'orientation' and 'exif'.
+	Include []string `json:"include,omitempty"`
 
 	Filter *PhotoFilter `json:"filter,omitempty"`
 }
 
 type SearchPhotosRequest struct {
 	Query string `json:"query"`
+	// Mode is either 'vector' (the default) for a pure similarity search, or
+	// 'hybrid' to also match the words of the query with the path.
+	Mode string `json:"mode,omitempty"`
+	// Arithmetic makes the query be parsed as comma-separated terms, whose
+	// embeddings are weighted and added up, like 'sunset 2x, mountains
+	// -people'. Otherwise, the query is embedded as a whole.
+	Arithmetic bool `json:"arithmetic,omitempty"`
+	// CollapseDuplicates makes the search return only the best result of each
+	// group of near-duplicates.
+	CollapseDuplicates bool `json:"collapseDuplicates,omitempty"`
+	// KeepExactDuplicates makes the search return all copies of the same file,
+	// rather than only the best one.
+	KeepExactDuplicates bool `json:"keepExactDuplicates,omitempty"`
+	PhotosRequestBase
+}
+
+type SearchPhotosByImageRequest struct {
 	PhotosRequestBase
 }
 
 type RecommendPhotosRequest struct {
-	Id string `json:"id"`
+	// Id is the ID of a single positive example.
+	Id string `json:"id,omitempty"`
+	RecommendExamples
 	PhotosRequestBase
 }
 
+type RecommendExamples struct {
+	// Positive and Negative hold the IDs of photos to find more and fewer
+	// photos like, respectively.
+	Positive []string `json:"positive,omitempty"`
+	Negative []string `json:"negative,omitempty"`
+	// PositiveQueries and NegativeQueries hold texts describing photos to find
+	// more and fewer photos like, respectively.
+	PositiveQueries []string `json:"positiveQueries,omitempty"`
+	NegativeQueries []string `json:"negativeQueries,omitempty"`
+	// Strategy is either 'averageVector' (the default) or 'bestScore'.
+	Strategy string `json:"strategy,omitempty"`
+}
+
+type BrowsePhotosRequest struct {
+	Limit *uint `json:"limit,omitempty"`
+	// Cursor is the cursor returned with the previous page, if any.
+	Cursor *string `json:"cursor,omitempty"`
+	// Order is either 'asc' or 'desc' (the default).
+	Order string `json:"order,omitempty"`
+	// Include lists the optional metadata to include with the results; any of
+	// 'dimensions', 'orientation' and 'exif'.
+	Include []string `json:"include,omitempty"`
+
+	Filter *PhotoFilter `json:"filter,omitempty"`
+}
+
 type PhotoFilter struct {
 	MinScore *float32 `json:"minScore,omitempty"`
 
@@ -24,16 +75,138 @@ type PhotoFilter struct {
 	NotAfter  *int64 `json:"notAfter,omitempty"`
 
 	OnThisDay *int64 `json:"onThisDay,omitempty"`
+
+	MediaTypes []string `json:"mediaTypes,omitempty"`
+
+	CameraMake  *string `json:"cameraMake,omitempty"`
+	CameraModel *string `json:"cameraModel,omitempty"`
+	Lens        *string `json:"lens,omitempty"`
+
+	MinIso         *float64 `json:"minIso,omitempty"`
+	MaxIso         *float64 `json:"maxIso,omitempty"`
+	MinAperture    *float64 `json:"minAperture,omitempty"`
+	MaxAperture    *float64 `json:"maxAperture,omitempty"`
+	MinFocalLength *float64 `json:"minFocalLength,omitempty"`
+	MaxFocalLength *float64 `json:"maxFocalLength,omitempty"`
+
+	// BoundingBox limits the results to photos taken within the box.
+	BoundingBox *GeoBoundingBox `json:"boundingBox,omitempty"`
+	// Near limits the results to photos taken within a radius around a point.
+	Near *GeoRadius `json:"near,omitempty"`
+	// Places limits the results to photos taken in any of the given cities,
+	// regions or countries, matched exactly by name or country code.
+	Places []string `json:"places,omitempty"`
+	// AlbumId limits the results to the photos in the album.
+	AlbumId *string `json:"albumId,omitempty"`
+	// FavoritesOnly and MinRating limit the results to the photos the user
+	// marked as favorites, or rated with at least the given stars (1 to 5).
+	FavoritesOnly bool `json:"favoritesOnly,omitempty"`
+	MinRating     *int `json:"minRating,omitempty"`
+	// Tags limits the results to the photos with any (the default) or all
+	// (if TagsMode is 'all') of the given tags.
+	Tags     []string `json:"tags,omitempty"`
+	TagsMode string   `json:"tagsMode,omitempty"`
+}
+
+// GeoBoundingBox is a box bounded by latitudes and longitudes, in decimal
+// degrees. If West is greater than East, the box crosses the antimeridian.
+type GeoBoundingBox struct {
+	North float64 `json:"north"`
+	South float64 `json:"south"`
+	East  float64 `json:"east"`
+	West  float64 `json:"west"`
+}
+
+type GeoRadius struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Radius is the radius around the point, in meters.
+	Radius float64 `json:"radius"`
 }
 
 type PhotoResultsResponse struct {
 	Items []*PhotoResultItem `json:"items"`
+	// Cursor is used to get the next page of results, if there is one.
+	Cursor *string `json:"cursor,omitempty"`
 }
 
 type PhotoResultItem struct {
 	Id        string `json:"id"`
 	Path      string `json:"path"`
 	Timestamp *int64 `json:"timestamp,omitempty"`
+	MediaType string `json:"mediaType"`
+
+	Score       *float32       `json:"score,omitempty"`
+	Width       *int64         `json:"width,omitempty"`
+	Height      *int64         `json:"height,omitempty"`
+	Orientation *int64         `json:"orientation,omitempty"`
+	Exif        map[string]any `json:"exif,omitempty"`
+}
+
+type PhotoDetailsResponse struct {
+	Id          string         `json:"id"`
+	Path        string         `json:"path"`
+	Timestamp   *int64         `json:"timestamp,omitempty"`
+	MediaType   string         `json:"mediaType"`
+	FileSize    *int64         `json:"fileSize,omitempty"`
+	Width       *int64         `json:"width,omitempty"`
+	Height      *int64         `json:"height,omitempty"`
+	Orientation *int64         `json:"orientation,omitempty"`
+	Location    *Location      `json:"location,omitempty"`
+	Place       *Place         `json:"place,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Exif        map[string]any `json:"exif"`
+}
+
+type Location struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+type Place struct {
+	City        string `json:"city,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"countryCode,omitempty"`
+}
+
+type DuplicatesResponse struct {
+	Groups []*DuplicateGroup `json:"groups"`
+	// Cursor is used to get the next page of groups, if there is one.
+	Cursor *string `json:"cursor,omitempty"`
+}
+
+type DuplicateGroup struct {
+	Id    string             `json:"id"`
+	Items []*PhotoResultItem `json:"items"`
+}
+
+type GeoClustersResponse struct {
+	Clusters []*GeoCluster `json:"clusters"`
+}
+
+type GeoCluster struct {
+	// Latitude and Longitude are the center of the photos in the cluster.
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Count     uint64  `json:"count"`
+	// Id is the ID of one of the photos in the cluster.
+	Id     string          `json:"id"`
+	Bounds *GeoBoundingBox `json:"bounds"`
+}
+
+type FacetsResponse struct {
+	Years        []*FacetCount `json:"years"`
+	Months       []*FacetCount `json:"months"`
+	CameraMakes  []*FacetCount `json:"cameraMakes"`
+	CameraModels []*FacetCount `json:"cameraModels"`
+	Folders      []*FacetCount `json:"folders"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
 }
 
 type EmbeddingResponse struct {
@@ -50,3 +223,120 @@ type OAuthSettings struct {
 	Audience string `json:"-" yaml:"audience"`
 	Issuer   string `json:"-" yaml:"issuer"`
 }
+
+// Album is a user-curated collection of photos.
+type Album struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// PhotoIds holds the IDs of the photos in the album, in the order they
+	// were added; it's not included when listing albums.
+	PhotoIds   []string `json:"photoIds,omitempty"`
+	PhotoCount int      `json:"photoCount"`
+	Created    int64    `json:"created"`
+	Updated    int64    `json:"updated"`
+}
+
+type AlbumsResponse struct {
+	Albums []*Album `json:"albums"`
+}
+
+// AlbumRequest creates or updates an album. When updating, fields which are
+// not set are left unchanged.
+type AlbumRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+type AlbumPhotosRequest struct {
+	Ids []string `json:"ids"`
+}
+
+// PhotoRating is the rating of a photo by a user.
+type PhotoRating struct {
+	Id       string `json:"id"`
+	Rating   int    `json:"rating"`
+	Favorite bool   `json:"favorite"`
+	Updated  int64  `json:"updated,omitempty"`
+}
+
+// PhotoRatingRequest sets the rating of a photo; fields which are not set are
+// left unchanged, and a rating of 0 removes the stars.
+type PhotoRatingRequest struct {
+	Rating   *int  `json:"rating,omitempty"`
+	Favorite *bool `json:"favorite,omitempty"`
+}
+
+type TagsResponse struct {
+	Tags []*TagCount `json:"tags"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+type TagPhotosRequest struct {
+	Ids  []string `json:"ids"`
+	Tags []string `json:"tags"`
+}
+
+// ArchivePhotosRequest requests a ZIP archive of photos. Photos are resized
+// to fit into MaxDimension if set; re-encoded photos have no metadata.
+type ArchivePhotosRequest struct {
+	Ids []string `json:"ids"`
+	// KeepFolders tells whether to keep the folder structure of the photos;
+	// otherwise all photos are put into the archive's root.
+	KeepFolders  bool `json:"keepFolders,omitempty"`
+	MaxDimension *int `json:"maxDimension,omitempty"`
+	// StripMetadata tells whether to strip EXIF and GPS metadata from photos;
+	// videos are left out of the archive then, and listed in its errors.txt.
+	StripMetadata bool `json:"stripMetadata,omitempty"`
+}
+
+type TagPhotosResponse struct {
+	// Updated is the number of photos whose tags changed.
+	Updated int `json:"updated"`
+}
+
+// Share grants anonymous access to view an album or a list of photos until it
+// expires or is revoked.
+type Share struct {
+	Id          string   `json:"id"`
+	AlbumId     *string  `json:"albumId,omitempty"`
+	PhotoIds    []string `json:"photoIds,omitempty"`
+	HasPassword bool     `json:"hasPassword"`
+	Created     int64    `json:"created"`
+	Expires     int64    `json:"expires"`
+	// Token is the signed token of the share, and Url the (relative) URL at
+	// which the shared photos can be viewed.
+	Token string `json:"token"`
+	Url   string `json:"url"`
+}
+
+type SharesResponse struct {
+	Shares []*Share `json:"shares"`
+}
+
+// ShareRequest creates a share of either an album or a list of photos.
+type ShareRequest struct {
+	AlbumId  *string  `json:"albumId,omitempty"`
+	PhotoIds []string `json:"photoIds,omitempty"`
+	// ExpiresIn is the number of seconds until the share expires.
+	ExpiresIn *int64  `json:"expiresIn,omitempty"`
+	Password  *string `json:"password,omitempty"`
+}
+
+type SharedPhotosResponse struct {
+	// Name is the name of the shared album, if any.
+	Name    string             `json:"name,omitempty"`
+	Expires int64              `json:"expires"`
+	Items   []*SharedPhotoItem `json:"items"`
+}
+
+// SharedPhotoItem is a shared photo, without the folder it's in.
+type SharedPhotoItem struct {
+	Id        string `json:"id"`
+	Timestamp *int64 `json:"timestamp,omitempty"`
+	MediaType string `json:"mediaType"`
+}
@@ -4,19 +4,27 @@ import (
 	"encoding/json"
 	"errors"
 	"image"
-	"image/jpeg"
+	"io"
 	"net/http"
+	"os"
 	"path"
 	"strconv"
 
 	"github.com/disintegration/imaging"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	pb "github.com/qdrant/go-client/qdrant"
 	"github.com/rokeller/photo-search/srv/web/models"
 )
 
+// PHOTO_CACHE_CONTROL is the cache-control header of photos and thumbnails,
+// which don't change for a photo ID.
+const PHOTO_CACHE_CONTROL = "max-age=31556736, immutable"
+
 type publicServerContext struct {
 	*serverContext
+	// photoCacheControl is the cache-control header of photos and thumbnails.
+	photoCacheControl string
 }
 
 func NewPublicServer(ctx *serverContext) *http.Server {
@@ -27,7 +35,8 @@ func NewPublicServer(ctx *serverContext) *http.Server {
 	}
 
 	publicCtx := publicServerContext{
-		serverContext: ctx,
+		serverContext:     ctx,
+		photoCacheControl: PHOTO_CACHE_CONTROL,
 	}
 
 	wellKnownRouter := mux.PathPrefix("/.well-known").Subrouter()
@@ -42,6 +51,12 @@ func NewPublicServer(ctx *serverContext) *http.Server {
 	apiRouter.Use(authMiddleware.Middleware)
 	publicCtx.addV1API(apiRouter)
 
+	// Shares are accessible without authentication.
+	shareRouter := mux.PathPrefix("/s/{token}").Subrouter()
+	shareCtx := publicCtx
+	shareCtx.photoCacheControl = SHARED_PHOTO_CACHE_CONTROL
+	shareServerContext{publicServerContext: shareCtx}.addShareRoutes(shareRouter)
+
 	spa := spaHandler{staticPath: "dist", indexPath: "index.html"}
 	mux.PathPrefix("/").Handler(spa)
 
@@ -64,13 +79,88 @@ func (c publicServerContext) addV1API(mux *mux.Router) {
 		Methods("POST").
 		HeadersRegexp("Content-Type", "(text|application)/json")
 
+	mux.HandleFunc("/photos/search-by-image", c.handleV1SearchPhotosByImage).
+		Methods("POST").
+		HeadersRegexp("Content-Type", "multipart/form-data")
+
 	mux.HandleFunc("/photos/recommend", c.handleV1RecommendPhotos).
 		Methods("POST").
 		HeadersRegexp("Content-Type", "(text|application)/json")
 
+	mux.HandleFunc("/photos/browse", c.handleV1BrowsePhotos).
+		Methods("POST").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/facets", c.handleV1GetFacets).
+		Methods("GET")
+
+	mux.HandleFunc("/duplicates", c.handleV1GetDuplicates).
+		Methods("GET")
+
+	mux.HandleFunc("/geo/clusters", c.handleV1GetGeoClusters).
+		Methods("GET")
+
+	mux.HandleFunc("/tags", c.handleV1ListTags).
+		Methods("GET")
+
+	// Must be registered before the photos by ID, which would match too.
+	mux.HandleFunc("/photos/tags", c.handleV1TagPhotos).
+		Methods("POST", "DELETE").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/photos/archive", c.handleV1ArchivePhotos).
+		Methods("POST").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/shares", c.handleV1ListShares).
+		Methods("GET")
+
+	mux.HandleFunc("/shares", c.handleV1CreateShare).
+		Methods("POST").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/shares/{shareId}", c.handleV1RevokeShare).
+		Methods("DELETE")
+
+	mux.HandleFunc("/albums", c.handleV1ListAlbums).
+		Methods("GET")
+
+	mux.HandleFunc("/albums", c.handleV1CreateAlbum).
+		Methods("POST").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/albums/{albumId}", c.handleV1GetAlbum).
+		Methods("GET")
+
+	mux.HandleFunc("/albums/{albumId}", c.handleV1UpdateAlbum).
+		Methods("PATCH").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/albums/{albumId}", c.handleV1DeleteAlbum).
+		Methods("DELETE")
+
+	mux.HandleFunc("/albums/{albumId}/photos", c.handleV1AddAlbumPhotos).
+		Methods("POST").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
+	mux.HandleFunc("/albums/{albumId}/photos", c.handleV1RemoveAlbumPhotos).
+		Methods("DELETE").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
 	mux.HandleFunc("/photos/{id}", c.handleV1PhotosGetById).
 		Methods("GET")
 
+	// Must be registered before the thumbnails, which would match too.
+	mux.HandleFunc("/photos/{id}/details", c.handleV1PhotoDetailsGetById).
+		Methods("GET")
+
+	mux.HandleFunc("/photos/{id}/rating", c.handleV1GetPhotoRating).
+		Methods("GET")
+
+	mux.HandleFunc("/photos/{id}/rating", c.handleV1SetPhotoRating).
+		Methods("PUT").
+		HeadersRegexp("Content-Type", "(text|application)/json")
+
 	mux.HandleFunc("/photos/{id}/{width}", c.handleV1PhotosWithWidthGetById).
 		Methods("GET")
 }
@@ -86,7 +176,54 @@ func (c publicServerContext) handleV1SearchPhotos(w http.ResponseWriter, r *http
 		limit = *req.Limit
 	}
 
-	res, err := c.search(req.Query, limit, req.Offset, req.Filter)
+	res, err := c.search(r.Context(), req.Query, req.Mode, req.Arithmetic, limit, req.Offset, req.Cursor, req.Include,
+		req.CollapseDuplicates, req.KeepExactDuplicates, req.Filter)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// handleV1SearchPhotosByImage searches photos similar to an uploaded image.
+// The image is expected in the 'image' part of the form, and the request in
+// the optional 'request' part, encoded as JSON.
+func (c publicServerContext) handleV1SearchPhotosByImage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_IMAGE_UPLOAD_SIZE+1<<20)
+	if err := r.ParseMultipartForm(MAX_IMAGE_UPLOAD_SIZE); nil != err {
+		glog.V(1).Infof("Failed to parse image upload: %v", err)
+		c.respondForError(InvalidImageUpload, w)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	req := &models.SearchPhotosByImageRequest{}
+	if request := r.FormValue("request"); "" != request {
+		if err := json.Unmarshal([]byte(request), req); nil != err {
+			c.respondForError(InvalidImageUpload, w)
+			return
+		}
+	}
+
+	var image []byte
+	if file, _, err := r.FormFile("image"); nil == err {
+		defer file.Close()
+		if image, err = io.ReadAll(io.LimitReader(file, MAX_IMAGE_UPLOAD_SIZE+1)); nil != err ||
+			len(image) > MAX_IMAGE_UPLOAD_SIZE {
+			c.respondForError(InvalidImageUpload, w)
+			return
+		}
+	}
+
+	limit := uint(10)
+	if nil != req.Limit {
+		limit = *req.Limit
+	}
+
+	res, err := c.searchByImage(r.Context(), image, limit, req.Offset, req.Cursor, req.Include, req.Filter)
 	if nil != err {
 		c.respondForError(err, w)
 	} else {
@@ -106,7 +243,171 @@ func (c publicServerContext) handleV1RecommendPhotos(w http.ResponseWriter, r *h
 		limit = *req.Limit
 	}
 
-	res, err := c.recommend(req.Id, limit, req.Offset, req.Filter)
+	examples := req.RecommendExamples
+	if "" != req.Id {
+		examples.Positive = append([]string{req.Id}, examples.Positive...)
+	}
+
+	res, err := c.recommend(r.Context(), examples, limit, req.Offset, req.Cursor, req.Include, req.Filter)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (c publicServerContext) handleV1BrowsePhotos(w http.ResponseWriter, r *http.Request) {
+	req := &models.BrowsePhotosRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	limit := uint(10)
+	if nil != req.Limit {
+		limit = *req.Limit
+	}
+
+	if "" != req.Order && "asc" != req.Order && "desc" != req.Order {
+		c.respondForError(InvalidBrowseOrder, w)
+		return
+	}
+
+	res, err := c.browse(r.Context(), limit, req.Cursor, "asc" != req.Order, req.Include, req.Filter)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (c publicServerContext) handleV1GetFacets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	res, err := c.getFacets(r.Context())
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (c publicServerContext) handleV1GetGeoClusters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	bbox, err := parseGeoBoundingBox(query.Get("bbox"))
+	if nil != err {
+		c.respondForError(err, w)
+		return
+	}
+
+	zoom, err := strconv.Atoi(query.Get("zoom"))
+	if nil != err || zoom < 0 || zoom > GEO_MAX_ZOOM {
+		c.respondForError(InvalidGeoQuery, w)
+		return
+	}
+
+	res, err := c.getGeoClusters(r.Context(), bbox, zoom)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (c publicServerContext) handleV1GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	limit := uint(10)
+	if limitStr := query.Get("limit"); "" != limitStr {
+		l, err := strconv.ParseUint(limitStr, 10, 32)
+		if nil != err || l < 1 {
+			c.respondForError(InvalidLimit, w)
+			return
+		}
+		limit = uint(l)
+	}
+
+	var cursor *string
+	if cursorStr := query.Get("cursor"); "" != cursorStr {
+		cursor = &cursorStr
+	}
+
+	res, err := c.listDuplicates(r.Context(), limit, cursor)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (c publicServerContext) handleV1ListTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	res, err := c.listTags(r.Context())
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// handleV1TagPhotos adds tags to photos with POST, and removes them with
+// DELETE.
+func (c publicServerContext) handleV1TagPhotos(w http.ResponseWriter, r *http.Request) {
+	req := &models.TagPhotosRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	res, err := c.tagPhotos(r.Context(), req.Ids, req.Tags, "DELETE" == r.Method)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// handleV1ArchivePhotos streams a ZIP archive of the requested photos. Errors
+// after the archive started streaming can only be logged.
+func (c publicServerContext) handleV1ArchivePhotos(w http.ResponseWriter, r *http.Request) {
+	req := &models.ArchivePhotosRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	entries, err := c.prepareArchive(r.Context(), req)
+	if nil != err {
+		w.Header().Add("content-type", "application/json; charset=utf-8")
+		c.respondForError(err, w)
+		return
+	}
+
+	w.Header().Add("content-type", "application/zip")
+	w.Header().Add("content-disposition", `attachment; filename="photos.zip"`)
+	w.WriteHeader(200)
+
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+	if err := c.writeArchive(w, entries, req, flush); nil != err {
+		glog.Errorf("Failed to stream archive of %d photos: %v", len(entries), err)
+	}
+}
+
+func (c publicServerContext) handleV1ListShares(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	res, err := c.listShares(r.Context())
 	if nil != err {
 		c.respondForError(err, w)
 	} else {
@@ -115,18 +416,183 @@ func (c publicServerContext) handleV1RecommendPhotos(w http.ResponseWriter, r *h
 	}
 }
 
+func (c publicServerContext) handleV1CreateShare(w http.ResponseWriter, r *http.Request) {
+	req := &models.ShareRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	res, err := c.createShare(r.Context(), req)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (c publicServerContext) handleV1RevokeShare(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := c.revokeShare(r.Context(), vars["shareId"]); nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(204)
+	}
+}
+
+func (c publicServerContext) handleV1ListAlbums(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	albums, err := c.local.listAlbums()
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(models.AlbumsResponse{Albums: albums})
+	}
+}
+
+func (c publicServerContext) handleV1CreateAlbum(w http.ResponseWriter, r *http.Request) {
+	req := &models.AlbumRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	album, err := c.createAlbum(req)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(album)
+	}
+}
+
+func (c publicServerContext) handleV1GetAlbum(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	album, err := c.local.getAlbum(vars["albumId"])
+	c.respondForAlbum(album, err, w)
+}
+
+func (c publicServerContext) handleV1UpdateAlbum(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req := &models.AlbumRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	album, err := c.updateAlbum(vars["albumId"], req)
+	c.respondForAlbum(album, err, w)
+}
+
+func (c publicServerContext) handleV1DeleteAlbum(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := c.local.deleteAlbum(vars["albumId"]); nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(204)
+	}
+}
+
+func (c publicServerContext) handleV1AddAlbumPhotos(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req := &models.AlbumPhotosRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	album, err := c.addAlbumPhotos(r.Context(), vars["albumId"], req.Ids)
+	c.respondForAlbum(album, err, w)
+}
+
+func (c publicServerContext) handleV1RemoveAlbumPhotos(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req := &models.AlbumPhotosRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	album, err := c.removeAlbumPhotos(vars["albumId"], req.Ids)
+	c.respondForAlbum(album, err, w)
+}
+
+func (c publicServerContext) handleV1GetPhotoRating(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	rating, err := c.getRating(r.Context(), vars["id"])
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(rating)
+	}
+}
+
+func (c publicServerContext) handleV1SetPhotoRating(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req := &models.PhotoRatingRequest{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	rating, err := c.setRating(r.Context(), vars["id"], req)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(rating)
+	}
+}
+
+func (c publicServerContext) respondForAlbum(album *models.Album, err error, w http.ResponseWriter) {
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(album)
+	}
+}
+
 func (c publicServerContext) handleV1PhotosGetById(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	payload, err := c.getPayloadById(id)
+	if nil != err {
+		c.respondForError(err, w)
+		return
+	}
+
+	relPath := getPathFromPayload(payload)
+	absPath := path.Join(c.photosRootDir, *relPath)
+	if !getImageDecoder(absPath).browserSupported {
+		// Browsers can't display the original, so transcode it at full size.
+		c.serveRenderedPhoto(w, r, id, payload, 0)
+		return
+	}
+
+	w.Header().Add("cache-control", c.photoCacheControl)
+	http.ServeFile(w, r, absPath)
+}
+
+func (c publicServerContext) handleV1PhotoDetailsGetById(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	res, err := c.getPhotoDetails(id)
 	if nil != err {
 		c.respondForError(err, w)
 	} else {
-		relPath := getPathFromPayload(payload)
-		absPath := path.Join(c.photosRootDir, *relPath)
-		w.Header().Add("cache-control", "max-age=31556736, immutable")
-		http.ServeFile(w, r, absPath)
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
 	}
 }
 
@@ -136,9 +602,11 @@ func (c publicServerContext) handleV1PhotosWithWidthGetById(w http.ResponseWrite
 	widthStr := vars["width"]
 
 	width, err := strconv.Atoi(widthStr)
-	if nil != err {
-		w.WriteHeader(400)
+	if nil != err || width < 1 || width > MAX_THUMBNAIL_WIDTH {
+		c.respondForError(InvalidThumbnailWidth, w)
+		return
 	}
+	width = snapThumbnailWidth(width, c.thumbnailWidths)
 
 	payload, err := c.getPayloadById(id)
 	if nil != err {
@@ -146,34 +614,60 @@ func (c publicServerContext) handleV1PhotosWithWidthGetById(w http.ResponseWrite
 		return
 	}
 
+	c.serveRenderedPhoto(w, r, id, payload, width)
+}
+
+// serveRenderedPhoto serves the photo resized to the given width, or at full
+// size if width is 0, encoded in the format negotiated with the client.
+func (c publicServerContext) serveRenderedPhoto(
+	w http.ResponseWriter,
+	r *http.Request,
+	id string,
+	payload map[string]*pb.Value,
+	width int,
+) {
 	relPath := getPathFromPayload(payload)
 	absPath := path.Join(c.photosRootDir, *relPath)
-	image, err := resizeImage(absPath, width)
-	if nil != err {
-		w.WriteHeader(500)
-		return
+	format := negotiateThumbnailFormat(r.Header.Get("Accept"))
+	render := func() ([]byte, error) {
+		return renderThumbnail(absPath, width, getOrientationFromPayload(payload), format)
 	}
 
-	orientation := getOrientationFromPayload(payload)
-	if nil != orientation {
-		// Apply the reverse transformation of the orientation in the EXIF tags
-		// to put the photo into the right shape again.
-		image = realignImage(image, *orientation)
+	var data []byte
+	var err error
+	if nil != c.thumbnails {
+		var fi os.FileInfo
+		fi, err = os.Stat(absPath)
+		if nil == err {
+			data, err = c.thumbnails.Get(thumbnailCacheKey(id, width, format, fi), render)
+		}
 	} else {
-		glog.V(1).Infof("Missing 'Orientation' tag in '%s'.", *relPath)
+		data, err = render()
+	}
+
+	if nil != err {
+		glog.Errorf("Failed to get thumbnail for '%s': %v", *relPath, err)
+		c.respondForError(err, w)
+		return
 	}
 
-	w.Header().Add("cache-control", "max-age=31556736, immutable")
-	w.Header().Add("content-type", "image/jpeg")
+	w.Header().Add("cache-control", c.photoCacheControl)
+	w.Header().Add("vary", "Accept")
+	w.Header().Add("content-type", format.mimeType)
+	w.Header().Add("content-length", strconv.Itoa(len(data)))
 
-	jpeg.Encode(w, image, &jpeg.Options{Quality: 66})
+	w.Write(data)
 }
 
-func (c publicServerContext) respondForError(err error, w http.ResponseWriter) {
+func (c *serverContext) respondForError(err error, w http.ResponseWriter) {
 	var pserr *photoSearchError
 
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
 	if errors.As(err, &pserr) {
-		if pserr.recoverable {
+		if 0 != pserr.status {
+			w.WriteHeader(pserr.status)
+		} else if pserr.recoverable {
 			w.WriteHeader(503)
 		} else {
 			w.WriteHeader(500)
@@ -186,16 +680,48 @@ func (c publicServerContext) respondForError(err error, w http.ResponseWriter) {
 	}
 }
 
-func resizeImage(path string, newWidth int) (image.Image, error) {
-	image, err := imaging.Open(path)
+func resizeImage(path string, newWidth int) (image.Image, *imageDecoder, error) {
+	decoder := getImageDecoder(path)
+	image, err := decoder.decode(path)
 	if err != nil {
-		glog.Errorf("Failed to open photo file '%s': %v", path, err)
-		return nil, err
+		glog.Errorf("Failed to open photo file '%s' using %s decoder: %v",
+			path, decoder.name, err)
+		return nil, nil, err
+	}
+
+	if newWidth <= 0 || newWidth >= image.Bounds().Dx() {
+		// Never upscale photos.
+		return image, decoder, nil
 	}
 
 	resized := imaging.Resize(image, newWidth, 0, imaging.Lanczos)
 
-	return resized, nil
+	return resized, decoder, nil
+}
+
+func renderThumbnail(path string, width int, orientation *int64, format *thumbnailFormat) ([]byte, error) {
+	image, decoder, err := resizeImage(path, width)
+	if nil != err {
+		return nil, err
+	}
+
+	if decoder.autoOriented {
+		glog.V(2).Infof("Photo '%s' is already oriented by %s decoder.", path, decoder.name)
+	} else if nil != orientation {
+		// Apply the reverse transformation of the orientation in the EXIF tags
+		// to put the photo into the right shape again.
+		image = realignImage(image, *orientation)
+	} else {
+		glog.V(1).Infof("Missing 'Orientation' tag in '%s'.", path)
+	}
+
+	data, err := format.encode(image, *format.quality)
+	if nil != err {
+		glog.Errorf("Failed to encode %s thumbnail for '%s': %v", format.name, path, err)
+		return nil, err
+	}
+
+	return data, nil
 }
 
 func realignImage(img image.Image, orientation int64) image.Image {
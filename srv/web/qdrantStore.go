@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// qdrantPayloadIndexes holds the payload fields to index, and their types.
+var qdrantPayloadIndexes = map[string]pb.FieldType{
+	METADATA_MEDIA_TYPE: pb.FieldType_FieldTypeKeyword,
+	// Hybrid search matches the words of queries with the path.
+	METADATA_PATH: pb.FieldType_FieldTypeText,
+	// Ordering by timestamp requires an index.
+	METADATA_TIMESTAMP:    pb.FieldType_FieldTypeInteger,
+	METADATA_GEO:          pb.FieldType_FieldTypeGeo,
+	METADATA_DUP_GROUP:    pb.FieldType_FieldTypeKeyword,
+	METADATA_CONTENT_HASH: pb.FieldType_FieldTypeKeyword,
+	METADATA_TAGS:         pb.FieldType_FieldTypeKeyword,
+
+	placeKey(PLACE_CITY):         pb.FieldType_FieldTypeKeyword,
+	placeKey(PLACE_REGION):       pb.FieldType_FieldTypeKeyword,
+	placeKey(PLACE_COUNTRY):      pb.FieldType_FieldTypeKeyword,
+	placeKey(PLACE_COUNTRY_CODE): pb.FieldType_FieldTypeKeyword,
+
+	METADATA_EXIF + "." + EXIF_CAMERA_MAKE:  pb.FieldType_FieldTypeKeyword,
+	METADATA_EXIF + "." + EXIF_CAMERA_Model: pb.FieldType_FieldTypeKeyword,
+	METADATA_EXIF + "." + EXIF_LENS_MODEL:   pb.FieldType_FieldTypeKeyword,
+	METADATA_EXIF + "." + EXIF_ISO:          pb.FieldType_FieldTypeInteger,
+	METADATA_EXIF + "." + EXIF_F_NUMBER:     pb.FieldType_FieldTypeFloat,
+	METADATA_EXIF + "." + EXIF_FOCAL_LENGTH: pb.FieldType_FieldTypeFloat,
+}
+
+// qdrantStore is a VectorStore backed by a qdrant server, accessed via gRPC.
+type qdrantStore struct {
+	conn *grpc.ClientConn
+	coll string
+}
+
+func newQdrantStore(addr, coll string) (*qdrantStore, error) {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if nil != err {
+		glog.Errorf("Failed to connect to qdrant '%s' gRPC: %v", addr, err)
+		return nil, err
+	}
+
+	return &qdrantStore{
+		conn: conn,
+		coll: coll,
+	}, nil
+}
+
+func (s *qdrantStore) Close() error {
+	return s.conn.Close()
+}
+
+func (s *qdrantStore) EnsureCollection() error {
+	client := pb.NewCollectionsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info, err := client.Get(ctx,
+		&pb.GetCollectionInfoRequest{CollectionName: s.coll})
+	if nil != err {
+		code := status.Code(err)
+
+		switch code {
+		case codes.NotFound:
+			return s.createCollection()
+
+		case codes.Unavailable, codes.DeadlineExceeded:
+			glog.Errorf("Vector database is unavailable: %v; grpc code = %v", err, code)
+			return VectorDatabaseUnavailable
+
+		default:
+			glog.Errorf("Failed to get collection details for '%s': %v; grpc code = %v", s.coll, err, code)
+			return err
+		}
+	}
+
+	return s.ensurePayloadIndexes(info.GetResult().GetPayloadSchema())
+}
+
+func (s *qdrantStore) createCollection() error {
+	glog.V(1).Infof("Collection '%s' does not exist, creating it ...", s.coll)
+
+	client := pb.NewCollectionsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.Create(ctx, &pb.CreateCollection{
+		CollectionName: s.coll,
+		VectorsConfig: &pb.VectorsConfig{
+			Config: &pb.VectorsConfig_Params{
+				Params: &pb.VectorParams{
+					Size:     VECTOR_SIZE,
+					Distance: pb.Distance_Cosine,
+				},
+			},
+		},
+	})
+	if nil != err {
+		glog.Errorf("Failed to create collection '%s': %v", s.coll, err)
+		return err
+	}
+
+	glog.Infof("Collection '%s' successfully created.", s.coll)
+
+	return s.ensurePayloadIndexes(nil)
+}
+
+// ensurePayloadIndexes creates the payload indexes which are not in the given
+// payload schema yet.
+func (s *qdrantStore) ensurePayloadIndexes(schema map[string]*pb.PayloadSchemaInfo) error {
+	client := pb.NewPointsClient(s.conn)
+
+	for key, fieldType := range qdrantPayloadIndexes {
+		if _, found := schema[key]; found {
+			continue
+		}
+
+		glog.V(1).Infof("Creating payload index for '%s' in collection '%s' ...", key, s.coll)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := client.CreateFieldIndex(ctx, &pb.CreateFieldIndexCollection{
+			CollectionName: s.coll,
+			FieldName:      key,
+			FieldType:      &fieldType,
+		})
+		cancel()
+
+		if nil != err {
+			code := status.Code(err)
+			glog.Errorf("Failed to create payload index for '%s': %v; grpc code: %v", key, err, code)
+			return translateGrpcError(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *qdrantStore) Scroll(ctx context.Context, params scrollParams) ([]*pb.RetrievedPoint, *string, error) {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req := pb.ScrollPoints{
+		CollectionName: s.coll,
+		Filter:         params.Filter,
+		WithPayload:    makePayloadSelector(params.Fields),
+		WithVectors: &pb.WithVectorsSelector{
+			SelectorOptions: &pb.WithVectorsSelector_Enable{
+				Enable: false,
+			},
+		},
+		Limit:   &params.PageSize,
+		OrderBy: params.OrderBy,
+	}
+	if params.Offset != nil && params.OrderBy == nil {
+		req.Offset = makePointId(*params.Offset)
+	}
+	resp, err := client.Scroll(ctx, &req)
+	if err != nil {
+		code := status.Code(err)
+		glog.Errorf("Failed to scroll points: %v; grpc code: %v", err, code)
+		return nil, nil, translateGrpcError(err)
+	}
+
+	var nextOffset *string
+	if resp.NextPageOffset != nil {
+		nextUuid := resp.NextPageOffset.GetUuid()
+		nextOffset = &nextUuid
+	}
+
+	return resp.Result, nextOffset, nil
+}
+
+func (s *qdrantStore) Upsert(points []*pb.PointStruct) error {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.Upsert(ctx, &pb.UpsertPoints{
+		CollectionName: s.coll,
+		Points:         points,
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to upsert points: %v; grpc code: %v", err, code)
+		return translateGrpcError(err)
+	}
+
+	return nil
+}
+
+func (s *qdrantStore) Delete(ids []string) error {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.Delete(ctx, &pb.DeletePoints{
+		CollectionName: s.coll,
+		Points:         makePointsSelector(ids),
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to delete points: %v; grpc code: %v", err, code)
+		return translateGrpcError(err)
+	}
+
+	return nil
+}
+
+func (s *qdrantStore) GetPoints(ctx context.Context, ids []string, fields []string) ([]*pb.RetrievedPoint, error) {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	pointIds := make([]*pb.PointId, len(ids))
+	for i, id := range ids {
+		pointIds[i] = makePointId(id)
+	}
+
+	r, err := client.Get(ctx, &pb.GetPoints{
+		CollectionName: s.coll,
+		Ids:            pointIds,
+		WithPayload:    makePayloadSelector(fields),
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to get %d point(s): %v; grpc code: %v", len(ids), err, code)
+		return nil, translateGrpcError(err)
+	}
+
+	return r.Result, nil
+}
+
+func (s *qdrantStore) GetPayload(id string) (map[string]*pb.Value, error) {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := client.Get(ctx, &pb.GetPoints{
+		CollectionName: s.coll,
+		Ids:            []*pb.PointId{makePointId(id)},
+		WithPayload: &pb.WithPayloadSelector{
+			SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true},
+		},
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to get point details for '%s': %v; grpc code: %v",
+			id, err, code)
+		return nil, translateGrpcError(err)
+	}
+
+	if len(r.Result) < 1 {
+		return nil, PhotoNotFound
+	}
+
+	return r.Result[0].Payload, nil
+}
+
+func (s *qdrantStore) SetPayload(ids []string, payload map[string]*pb.Value) error {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.SetPayload(ctx, &pb.SetPayloadPoints{
+		CollectionName: s.coll,
+		Payload:        payload,
+		PointsSelector: makePointsSelector(ids),
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to set payload of points: %v; grpc code: %v", err, code)
+		return translateGrpcError(err)
+	}
+
+	return nil
+}
+
+func (s *qdrantStore) DeletePayload(ids []string, keys []string) error {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.DeletePayload(ctx, &pb.DeletePayloadPoints{
+		CollectionName: s.coll,
+		Keys:           keys,
+		PointsSelector: makePointsSelector(ids),
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to delete payload of points: %v; grpc code: %v", err, code)
+		return translateGrpcError(err)
+	}
+
+	return nil
+}
+
+func (s *qdrantStore) Search(vector []float32, params searchParams) ([]*pb.ScoredPoint, error) {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := client.Search(ctx, &pb.SearchPoints{
+		CollectionName: s.coll,
+		Vector:         vector,
+		Limit:          params.Limit,
+		Offset:         &params.Offset,
+		Filter:         params.Filter,
+		ScoreThreshold: params.ScoreThreshold,
+		WithPayload: &pb.WithPayloadSelector{
+			SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true},
+		},
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to search vectors: %v; grpc code: %v", err, code)
+		return nil, translateGrpcError(err)
+	}
+
+	return r.Result, nil
+}
+
+func (s *qdrantStore) Recommend(examples recommendExamples, params searchParams) ([]*pb.ScoredPoint, error) {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := client.Recommend(ctx, s.makeRecommendPoints(examples, params, true))
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to recommend similar for %v (not %v): %v; grpc code: %v",
+			examples.Positive, examples.Negative, err, code)
+		return nil, translateGrpcError(err)
+	}
+
+	return r.Result, nil
+}
+
+func (s *qdrantStore) RecommendBatch(examples []recommendExamples, params searchParams) ([][]*pb.ScoredPoint, error) {
+	client := pb.NewPointsClient(s.conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	batch := make([]*pb.RecommendPoints, len(examples))
+	for i, e := range examples {
+		batch[i] = s.makeRecommendPoints(e, params, false)
+	}
+
+	r, err := client.RecommendBatch(ctx, &pb.RecommendBatchPoints{
+		CollectionName:  s.coll,
+		RecommendPoints: batch,
+	})
+	if nil != err {
+		code := status.Code(err)
+		glog.Errorf("Failed to recommend similar in batch of %d: %v; grpc code: %v",
+			len(examples), err, code)
+		return nil, translateGrpcError(err)
+	}
+
+	results := make([][]*pb.ScoredPoint, len(r.Result))
+	for i, result := range r.Result {
+		results[i] = result.Result
+	}
+
+	return results, nil
+}
+
+func (s *qdrantStore) makeRecommendPoints(
+	examples recommendExamples,
+	params searchParams,
+	withPayload bool,
+) *pb.RecommendPoints {
+	return &pb.RecommendPoints{
+		CollectionName:  s.coll,
+		Positive:        makePointIds(examples.Positive),
+		Negative:        makePointIds(examples.Negative),
+		PositiveVectors: makeVectors(examples.PositiveVectors),
+		NegativeVectors: makeVectors(examples.NegativeVectors),
+		Strategy:        &examples.Strategy,
+		Limit:           params.Limit,
+		Offset:          &params.Offset,
+		Filter:          params.Filter,
+		ScoreThreshold:  params.ScoreThreshold,
+		WithPayload: &pb.WithPayloadSelector{
+			SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: withPayload},
+		},
+	}
+}
+
+func makePointIds(ids []string) []*pb.PointId {
+	pointIds := make([]*pb.PointId, len(ids))
+	for i, id := range ids {
+		pointIds[i] = makePointId(id)
+	}
+
+	return pointIds
+}
+
+func makePointsSelector(ids []string) *pb.PointsSelector {
+	return &pb.PointsSelector{
+		PointsSelectorOneOf: &pb.PointsSelector_Points{
+			Points: &pb.PointsIdsList{
+				Ids: makePointIds(ids),
+			},
+		},
+	}
+}
+
+func makeVectors(vectors [][]float32) []*pb.Vector {
+	result := make([]*pb.Vector, len(vectors))
+	for i, v := range vectors {
+		result[i] = &pb.Vector{Data: v}
+	}
+
+	return result
+}
+
+func makePayloadSelector(fields []string) *pb.WithPayloadSelector {
+	if len(fields) < 1 {
+		return &pb.WithPayloadSelector{
+			SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true},
+		}
+	}
+
+	return &pb.WithPayloadSelector{
+		SelectorOptions: &pb.WithPayloadSelector_Include{
+			Include: &pb.PayloadIncludeSelector{
+				Fields: fields,
+			},
+		},
+	}
+}
+
+// translateGrpcError maps errors from the qdrant gRPC API to the errors
+// reported by the photo search APIs.
+func translateGrpcError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return VectorDatabaseUnavailable
+
+	default:
+		return err
+	}
+}
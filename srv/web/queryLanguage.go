@@ -0,0 +1,128 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/golang/glog"
+)
+
+// weightRegex matches the weight of a query term, like '2x' or '0.5x'.
+var weightRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)x$`)
+
+// queryTerm is a single term of a search query, which is embedded separately.
+type queryTerm struct {
+	text    string
+	weight  float32
+	negated bool
+}
+
+// parseQuery parses a search query for vector arithmetic into its terms.
+// Terms are separated by commas, and a word starting with '-' starts a negated
+// term. A word like '2x' sets the weight of the term it follows. For example,
+// 'sunset 2x, mountains -people' has the terms 'sunset' with weight 2,
+// 'mountains' with weight 1 and the negated term 'people' with weight 1. At
+// least one term must not be negated.
+func parseQuery(query string) ([]queryTerm, error) {
+	var terms []queryTerm
+	var words []string
+	current := queryTerm{weight: 1}
+
+	addTerm := func() {
+		if len(words) > 0 {
+			current.text = strings.Join(words, " ")
+			terms = append(terms, current)
+		}
+		words = nil
+		current = queryTerm{weight: 1}
+	}
+
+	for _, clause := range splitOutsideQuotes(query, func(r rune) bool { return ',' == r }) {
+		for _, word := range splitOutsideQuotes(clause, unicode.IsSpace) {
+			if match := weightRegex.FindStringSubmatch(word); nil != match && len(words) > 0 {
+				weight, err := strconv.ParseFloat(match[1], 32)
+				if nil != err || weight <= 0 {
+					return nil, InvalidQuery
+				}
+				current.weight = float32(weight)
+			} else if len(word) > 1 && strings.HasPrefix(word, "-") {
+				addTerm()
+				current.negated = true
+				words = append(words, word[1:])
+			} else {
+				words = append(words, word)
+			}
+		}
+		addTerm()
+	}
+
+	if len(terms) < 1 {
+		// An empty query is embedded as is.
+		return []queryTerm{{text: query, weight: 1}}, nil
+	}
+
+	for _, term := range terms {
+		if !term.negated {
+			return terms, nil
+		}
+	}
+
+	return nil, InvalidQuery
+}
+
+// splitOutsideQuotes splits text around the runes satisfying isSeparator,
+// except within quotes, dropping empty parts.
+func splitOutsideQuotes(text string, isSeparator func(rune) bool) []string {
+	quoted := false
+	return strings.FieldsFunc(text, func(r rune) bool {
+		if '"' == r {
+			quoted = !quoted
+		}
+		return !quoted && isSeparator(r)
+	})
+}
+
+// positiveText gets the text of the terms which are not negated, as used for
+// matching keywords in hybrid search.
+func positiveText(terms []queryTerm) string {
+	var texts []string
+	for _, term := range terms {
+		if !term.negated {
+			texts = append(texts, term.text)
+		}
+	}
+
+	return strings.Join(texts, " ")
+}
+
+// getQueryEmbedding calculates the embedding for a parsed search query. The
+// embeddings of the terms are combined by adding the weighted embeddings of
+// the terms, and subtracting those of the negated terms. This is no boolean
+// AND or NOT: photos close to the sum rank highest, even if they match only
+// some of the terms. Each term takes an embedding of its own.
+func (c *serverContext) getQueryEmbedding(terms []queryTerm) ([]float32, error) {
+	if 1 == len(terms) {
+		return c.getEmbedding(embeddingText(terms[0].text))
+	}
+
+	result := make([]float32, VECTOR_SIZE)
+	for _, term := range terms {
+		v, err := c.getEmbedding(embeddingText(term.text))
+		if nil != err {
+			glog.Errorf("Failed to get embedding for query term '%s': %v", term.text, err)
+			return nil, err
+		}
+
+		weight := term.weight
+		if term.negated {
+			weight = -weight
+		}
+		for i, f := range normalize(v) {
+			result[i] += weight * f
+		}
+	}
+
+	return normalize(result), nil
+}
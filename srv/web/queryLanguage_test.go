@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []queryTerm
+		err      error
+	}{
+		{"", []queryTerm{{text: "", weight: 1}}, nil},
+		{"sunset", []queryTerm{{text: "sunset", weight: 1}}, nil},
+		{"sunset over the sea", []queryTerm{{text: "sunset over the sea", weight: 1}}, nil},
+		{"sunset 2x, mountains -people", []queryTerm{
+			{text: "sunset", weight: 2},
+			{text: "mountains", weight: 1},
+			{text: "people", weight: 1, negated: true},
+		}, nil},
+		{"beach, -crowd 0.5x", []queryTerm{
+			{text: "beach", weight: 1},
+			{text: "crowd", weight: 0.5, negated: true},
+		}, nil},
+		// A weight without a term is a word of its own.
+		{"2x zoom", []queryTerm{{text: "2x zoom", weight: 1}}, nil},
+		{"well-known places, - ,", []queryTerm{{text: "well-known places", weight: 1}, {text: "-", weight: 1}}, nil},
+		// Separators within quotes are part of the term.
+		{`"rock, paper -scissors" 3x`, []queryTerm{{text: `"rock, paper -scissors"`, weight: 3}}, nil},
+		{" , ,", []queryTerm{{text: " , ,", weight: 1}}, nil},
+		{"sunset 0x", nil, InvalidQuery},
+		{"-people", nil, InvalidQuery},
+		{"-people, -cars", nil, InvalidQuery},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			actual, err := parseQuery(test.query)
+			if test.err != err {
+				t.Fatalf("parseQuery() error = %v, expected %v", err, test.err)
+			}
+			if !slices.Equal(test.expected, actual) {
+				t.Errorf("parseQuery() = %+v, expected %+v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestPositiveText(t *testing.T) {
+	terms, err := parseQuery("sunset 2x, mountains -people, lake")
+	if nil != err {
+		t.Fatalf("parseQuery() failed: %v", err)
+	}
+
+	if actual := positiveText(terms); "sunset mountains lake" != actual {
+		t.Errorf("positiveText() = '%s', expected 'sunset mountains lake'", actual)
+	}
+}
+
+func TestSearchArithmetic(t *testing.T) {
+	var queries []string
+	embeddings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.FormValue("query"))
+		json.NewEncoder(w).Encode(models.EmbeddingResponse{Vector: testVector(0, 1)})
+	}))
+	defer embeddings.Close()
+
+	c := &serverContext{
+		store:                    newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db")),
+		embeddingsServiceBaseUrl: embeddings.URL,
+		queryVectors:             newVectorCache(QUERY_VECTOR_CACHE_SIZE),
+	}
+
+	tests := []struct {
+		query      string
+		arithmetic bool
+		expected   []string
+		err        error
+	}{
+		{"sunset 2x, mountains -people", false, []string{"sunset 2x, mountains -people"}, nil},
+		{"-people", false, []string{"-people"}, nil},
+		{"sunset 2x, mountains -people", true, []string{"sunset", "mountains", "people"}, nil},
+		{"-people", true, nil, InvalidQuery},
+	}
+
+	for _, test := range tests {
+		queries = nil
+		_, err := c.search(context.Background(), test.query, "", test.arithmetic, 10, nil, nil, nil,
+			false, true, nil)
+		if test.err != err {
+			t.Errorf("search(%q, %t) error = %v, expected %v", test.query, test.arithmetic, err, test.err)
+		} else if !slices.Equal(test.expected, queries) {
+			t.Errorf("search(%q, %t) embedded %q, expected %q", test.query, test.arithmetic, queries, test.expected)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	MIN_RATING = 1
+	MAX_RATING = 5
+)
+
+// getRating gets the rating of a photo by the given subject. Photos which
+// were never rated get an empty rating.
+func (s *localStore) getRating(subject, id string) (*models.PhotoRating, error) {
+	rating := &models.PhotoRating{Id: id}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(RATINGS_BUCKET)).Bucket([]byte(subject))
+		if nil == bucket {
+			return nil
+		}
+
+		if v := bucket.Get([]byte(id)); nil != v {
+			return json.Unmarshal(v, rating)
+		}
+
+		return nil
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return rating, nil
+}
+
+// updateRating applies the given update to the rating of a photo by the given
+// subject. Ratings which are neither favorites nor have stars are removed.
+func (s *localStore) updateRating(
+	subject, id string,
+	update func(rating *models.PhotoRating),
+) (*models.PhotoRating, error) {
+	rating := &models.PhotoRating{Id: id}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket([]byte(RATINGS_BUCKET)).CreateBucketIfNotExists([]byte(subject))
+		if nil != err {
+			return err
+		}
+
+		if v := bucket.Get([]byte(id)); nil != v {
+			if err := json.Unmarshal(v, rating); nil != err {
+				return err
+			}
+		}
+
+		update(rating)
+		rating.Updated = time.Now().Unix()
+		if !rating.Favorite && 0 == rating.Rating {
+			return bucket.Delete([]byte(id))
+		}
+
+		v, err := json.Marshal(rating)
+		if nil != err {
+			return err
+		}
+
+		return bucket.Put([]byte(id), v)
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return rating, nil
+}
+
+// findRatedPhotos finds the IDs of the photos the given subject marked as
+// favorites (if favoritesOnly is set) and rated with at least minRating stars.
+func (s *localStore) findRatedPhotos(subject string, favoritesOnly bool, minRating int) ([]string, error) {
+	ids := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(RATINGS_BUCKET)).Bucket([]byte(subject))
+		if nil == bucket {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			rating := &models.PhotoRating{}
+			if err := json.Unmarshal(v, rating); nil != err {
+				return err
+			}
+
+			if (!favoritesOnly || rating.Favorite) && rating.Rating >= minRating {
+				ids = append(ids, string(k))
+			}
+
+			return nil
+		})
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (c *serverContext) getRating(ctx context.Context, id string) (*models.PhotoRating, error) {
+	subject := getSubject(ctx)
+	if "" == subject {
+		return nil, NotAuthenticated
+	}
+
+	return c.local.getRating(subject, id)
+}
+
+// setRating sets the star rating and favorite flag of a photo for the
+// authenticated subject. A rating of 0 removes the stars; fields which are not
+// set are left unchanged.
+func (c *serverContext) setRating(
+	ctx context.Context,
+	id string,
+	req *models.PhotoRatingRequest,
+) (*models.PhotoRating, error) {
+	subject := getSubject(ctx)
+	if "" == subject {
+		return nil, NotAuthenticated
+	} else if nil != req.Rating && 0 != *req.Rating &&
+		(*req.Rating < MIN_RATING || *req.Rating > MAX_RATING) {
+		return nil, InvalidRating
+	}
+
+	if _, err := c.store.GetPayload(id); nil != err {
+		return nil, err
+	}
+
+	return c.local.updateRating(subject, id, func(rating *models.PhotoRating) {
+		if nil != req.Rating {
+			rating.Rating = *req.Rating
+		}
+		if nil != req.Favorite {
+			rating.Favorite = *req.Favorite
+		}
+	})
+}
+
+// makeRatingsCondition makes a condition for the photos the authenticated
+// subject marked as favorites or rated with at least the given stars.
+func (c *serverContext) makeRatingsCondition(
+	ctx context.Context,
+	favoritesOnly bool,
+	minRating *int,
+) (*pb.Condition, error) {
+	stars := 0
+	if nil != minRating {
+		if *minRating < MIN_RATING || *minRating > MAX_RATING {
+			return nil, InvalidRating
+		}
+		stars = *minRating
+	}
+
+	// Without a subject, no photos match.
+	ids := []string{}
+	if subject := getSubject(ctx); "" != subject {
+		var err error
+		if ids, err = c.local.findRatedPhotos(subject, favoritesOnly, stars); nil != err {
+			return nil, err
+		}
+	}
+
+	return makeHasIdCondition(ids), nil
+}
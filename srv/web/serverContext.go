@@ -9,37 +9,106 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/golang/glog"
 	pb "github.com/qdrant/go-client/qdrant"
 	"github.com/rokeller/photo-search/srv/web/models"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	METADATA_PATH      = "path"
-	METADATA_TIMESTAMP = "timestamp"
-	METADATA_EXIF      = "exif"
+	METADATA_PATH         = "path"
+	METADATA_TIMESTAMP    = "timestamp"
+	METADATA_EXIF         = "exif"
+	METADATA_MEDIA_TYPE   = "media_type"
+	METADATA_GEO          = "geo"
+	METADATA_PLACE        = "place"
+	METADATA_DUP_GROUP    = "dup_group"
+	METADATA_CONTENT_HASH = "content_hash"
+	METADATA_TAGS         = "tags"
+
+	PLACE_CITY         = "city"
+	PLACE_REGION       = "region"
+	PLACE_COUNTRY      = "country"
+	PLACE_COUNTRY_CODE = "country_code"
+
+	QUERY_VECTOR_CACHE_SIZE = 1000
+	POINTS_PAGE_SIZE        = 1000
+
+	MEDIA_TYPE_PHOTO = "photo"
+	MEDIA_TYPE_VIDEO = "video"
 
 	EXIF_CAMERA_MAKE  = "Make"
 	EXIF_CAMERA_Model = "Model"
 	EXIF_ORIENTATION  = "Orientation"
+	EXIF_LENS_MODEL   = "LensModel"
+	EXIF_ISO          = "ISOSpeedRatings"
+	EXIF_F_NUMBER     = "FNumber"
+	EXIF_FOCAL_LENGTH = "FocalLength"
+
+	EXIF_EXPOSURE_TIME      = "ExposureTime"
+	EXIF_DATE_TIME_ORIGINAL = "DateTimeOriginal"
+	EXIF_IMAGE_WIDTH        = "ImageWidth"
+	EXIF_IMAGE_HEIGHT       = "ImageHeight"
+	EXIF_EXIF_IMAGE_WIDTH   = "ExifImageWidth"
+	EXIF_EXIF_IMAGE_HEIGHT  = "ExifImageHeight"
+
+	// The optional metadata clients can request for result items.
+	INCLUDE_SCORE       = "score"
+	INCLUDE_DIMENSIONS  = "dimensions"
+	INCLUDE_ORIENTATION = "orientation"
+	INCLUDE_EXIF        = "exif"
 )
 
+// PRESERVED_METADATA lists the payload fields which aren't indexed, but set
+// by the server, and are kept when a photo is indexed again.
+var PRESERVED_METADATA = []string{METADATA_TAGS, METADATA_DUP_GROUP}
+
+// resultExifTags holds the EXIF tags included in result items, if requested.
+var resultExifTags = []string{
+	EXIF_CAMERA_MAKE,
+	EXIF_CAMERA_Model,
+	EXIF_LENS_MODEL,
+	EXIF_ISO,
+	EXIF_F_NUMBER,
+	EXIF_FOCAL_LENGTH,
+	EXIF_EXPOSURE_TIME,
+	EXIF_DATE_TIME_ORIGINAL,
+}
+
+// exifNumericRationalTags holds the EXIF tags with rational values that are
+// stored as plain numbers, such that they can be filtered by range.
+var exifNumericRationalTags = []string{
+	EXIF_F_NUMBER,
+	EXIF_FOCAL_LENGTH,
+}
+
 type serverContext struct {
-	conn                     *grpc.ClientConn
-	coll                     string
+	store                    VectorStore
+	thumbnails               *thumbnailCache
+	thumbnailWidths          []int
+	queryVectors             *vectorCache
+	facets                   *facetsCache
+	duplicates               *duplicatesState
+	shareUnlocks             *shareUnlockThrottle
+	exifTags                 *exifTagPolicy
+	gazetteer                *gazetteer
+	local                    *localStore
 	embeddingsServiceBaseUrl string
+	imageEmbeddingsUrl       string
 	photosRootDir            string
 
+	// tagsMutex serializes updates of the tags of photos, which are read,
+	// modified and written back.
+	tagsMutex sync.Mutex
+
 	oauthSettings models.OAuthSettings
 }
 
@@ -48,24 +117,43 @@ type photoPathsResult struct {
 	NextOffset *string  `json:"next_offset,omitempty"`
 }
 
-func newServerContext(addr, coll, embeddingsServiceBaseUrl, photosRootDir string) (*serverContext, error) {
-	conn, err := grpc.NewClient(addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if nil != err {
-		glog.Exitf("Failed to connect to qdrant '%s' gRPC: %v", addr, err)
-		return nil, err
+func newServerContext(
+	store VectorStore,
+	thumbnails *thumbnailCache,
+	thumbnailWidths []int,
+	exifTags *exifTagPolicy,
+	gazetteer *gazetteer,
+	local *localStore,
+	embeddingsServiceBaseUrl, imageEmbeddingsUrl, photosRootDir string,
+) (*serverContext, error) {
+	embeddingsServiceBaseUrl = strings.TrimSuffix(embeddingsServiceBaseUrl, "/")
+	if "" == imageEmbeddingsUrl {
+		imageEmbeddingsUrl = embeddingsServiceBaseUrl + "/v1/embed/image"
 	}
 
 	ctx := &serverContext{
-		conn:                     conn,
-		coll:                     coll,
-		embeddingsServiceBaseUrl: strings.TrimSuffix(embeddingsServiceBaseUrl, "/"),
+		store:                    store,
+		thumbnails:               thumbnails,
+		thumbnailWidths:          thumbnailWidths,
+		queryVectors:             newVectorCache(QUERY_VECTOR_CACHE_SIZE),
+		facets:                   &facetsCache{},
+		duplicates:               &duplicatesState{},
+		shareUnlocks:             newShareUnlockThrottle(),
+		exifTags:                 exifTags,
+		gazetteer:                gazetteer,
+		local:                    local,
+		embeddingsServiceBaseUrl: embeddingsServiceBaseUrl,
+		imageEmbeddingsUrl:       imageEmbeddingsUrl,
 		photosRootDir:            photosRootDir,
 
 		oauthSettings: loadOAuthSettings(),
 	}
 
-	return ctx.ensureCollection()
+	if err := store.EnsureCollection(); nil != err {
+		return nil, err
+	}
+
+	return ctx, nil
 }
 
 func loadOAuthSettings() models.OAuthSettings {
@@ -86,104 +174,20 @@ func loadOAuthSettings() models.OAuthSettings {
 	return settings
 }
 
-func (c *serverContext) ensureCollection() (*serverContext, error) {
-	client := pb.NewCollectionsClient(c.conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := client.Get(ctx,
-		&pb.GetCollectionInfoRequest{CollectionName: c.coll})
-	if nil != err {
-		code := status.Code(err)
-
-		switch code {
-		case codes.NotFound:
-			return c.createCollection()
-
-		case codes.Unavailable, codes.DeadlineExceeded:
-			glog.Errorf("Vector database is unavailable: %v; grpc code = %v", err, code)
-			return nil, VectorDatabaseUnavailable
-
-		default:
-			glog.Errorf("Failed to get collection details for '%s': %v; grpc code = %v", c.coll, err, code)
-			return nil, err
-		}
-	}
-
-	return c, nil
-}
-
-func (c *serverContext) createCollection() (*serverContext, error) {
-	glog.V(1).Infof("Collection '%s' does not exist, creating it ...", c.coll)
-
-	client := pb.NewCollectionsClient(c.conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := client.Create(ctx, &pb.CreateCollection{
-		CollectionName: c.coll,
-		VectorsConfig: &pb.VectorsConfig{
-			Config: &pb.VectorsConfig_Params{
-				Params: &pb.VectorParams{
-					Size:     512,
-					Distance: pb.Distance_Cosine,
-				},
-			},
-		},
-	})
-	if nil != err {
-		defer c.conn.Close()
-		glog.Errorf("Failed to create collection '%s': %v", c.coll, err)
-		return nil, err
-	}
-
-	glog.Infof("Collection '%s' successfully created.", c.coll)
-
-	return c, nil
-}
-
 func (c *serverContext) getPhotoPaths(pageSize uint32, offset *string, ctx context.Context) (*photoPathsResult, error) {
-	client := pb.NewPointsClient(c.conn)
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
-	req := pb.ScrollPoints{
-		CollectionName: c.coll,
-		WithPayload: &pb.WithPayloadSelector{
-			SelectorOptions: &pb.WithPayloadSelector_Include{
-				Include: &pb.PayloadIncludeSelector{
-					Fields: []string{METADATA_PATH},
-				},
-			},
-		},
-		WithVectors: &pb.WithVectorsSelector{
-			SelectorOptions: &pb.WithVectorsSelector_Enable{
-				Enable: false,
-			},
-		},
-		Limit: &pageSize,
-	}
-	if offset != nil {
-		req.Offset = &pb.PointId{
-			PointIdOptions: &pb.PointId_Uuid{
-				Uuid: *offset,
-			},
-		}
-	}
-	resp, err := client.Scroll(ctx, &req)
+	points, nextOffset, err := c.store.Scroll(ctx, scrollParams{
+		PageSize: pageSize,
+		Offset:   offset,
+		Fields:   []string{METADATA_PATH},
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	paths := make([]string, len(resp.Result))
-	for i, path := range resp.Result {
+	paths := make([]string, len(points))
+	for i, path := range points {
 		paths[i] = *getPathFromPayload(path.Payload)
 	}
-	var nextOffset *string
-	if resp.NextPageOffset != nil {
-		nextUuid := resp.NextPageOffset.GetUuid()
-		nextOffset = &nextUuid
-	}
 
 	return &photoPathsResult{
 		Values:     paths,
@@ -192,6 +196,14 @@ func (c *serverContext) getPhotoPaths(pageSize uint32, offset *string, ctx conte
 }
 
 func (c *serverContext) upsert(items []*models.ItemToIndex) error {
+	for _, item := range items {
+		if nil != item.Payload.ContentHash && !isValidContentHash(*item.Payload.ContentHash) {
+			return InvalidContentHash
+		} else if nil != item.Payload.MediaType && !isValidMediaType(*item.Payload.MediaType) {
+			return InvalidMediaType
+		}
+	}
+
 	points := make([]*pb.PointStruct, len(items))
 
 	for i, item := range items {
@@ -208,6 +220,31 @@ func (c *serverContext) upsert(items []*models.ItemToIndex) error {
 			},
 		}
 
+		if location := getLocationFromPayload(payload); nil != location {
+			payload[METADATA_GEO] = makeGeoPayloadValue(location)
+
+			if place := c.gazetteer.lookup(location); nil != place {
+				payload[METADATA_PLACE] = makePlacePayloadValue(place)
+			}
+		}
+
+		mediaType := getImageDecoder(item.Payload.Path).mediaType
+		if nil != item.Payload.MediaType {
+			mediaType = *item.Payload.MediaType
+			if "" == mediaType {
+				mediaType = MEDIA_TYPE_PHOTO
+			}
+		}
+		payload[METADATA_MEDIA_TYPE] = &pb.Value{
+			Kind: &pb.Value_StringValue{StringValue: mediaType},
+		}
+
+		if hash := c.getContentHash(&item.Payload); "" != hash {
+			payload[METADATA_CONTENT_HASH] = &pb.Value{
+				Kind: &pb.Value_StringValue{StringValue: hash},
+			}
+		}
+
 		if nil != item.Payload.Timestamp {
 			payload[METADATA_TIMESTAMP] = &pb.Value{
 				Kind: &pb.Value_IntegerValue{IntegerValue: *item.Payload.Timestamp},
@@ -217,11 +254,7 @@ func (c *serverContext) upsert(items []*models.ItemToIndex) error {
 		}
 
 		points[i] = &pb.PointStruct{
-			Id: &pb.PointId{
-				PointIdOptions: &pb.PointId_Uuid{
-					Uuid: pathHash(item.Payload.Path),
-				},
-			},
+			Id:      makePointId(pathHash(item.Payload.Path)),
 			Payload: payload,
 			Vectors: &pb.Vectors{
 				VectorsOptions: &pb.Vectors_Vector{
@@ -233,209 +266,451 @@ func (c *serverContext) upsert(items []*models.ItemToIndex) error {
 		}
 	}
 
-	client := pb.NewPointsClient(c.conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Keep the tags and duplicate groups of photos which are indexed again.
+	ids := make([]string, len(points))
+	for i, point := range points {
+		ids[i] = point.Id.GetUuid()
+	}
 
-	_, err := client.Upsert(ctx, &pb.UpsertPoints{
-		CollectionName: c.coll,
-		Points:         points,
-	})
-	if nil != err {
-		code := status.Code(err)
-		glog.Errorf("Failed to upsert points: %v; grpc code: %v", err, code)
+	c.tagsMutex.Lock()
+	defer c.tagsMutex.Unlock()
 
-		switch code {
-		case codes.Unavailable, codes.DeadlineExceeded:
-			return VectorDatabaseUnavailable
+	existing, err := c.getPoints(context.Background(), ids, PRESERVED_METADATA...)
+	if nil != err {
+		return err
+	}
+	for _, point := range existing {
+		index := slices.Index(ids, point.Id.GetUuid())
+		if index < 0 {
+			continue
+		}
 
-		default:
-			return err
+		for _, field := range PRESERVED_METADATA {
+			if value, found := point.Payload[field]; found {
+				points[index].Payload[field] = value
+			}
 		}
 	}
 
+	if err := c.store.Upsert(points); nil != err {
+		return err
+	}
+
+	c.facets.invalidate()
+	c.duplicates.invalidate()
+
 	return nil
 }
 
 func (c *serverContext) delete(items []string) error {
-	client := pb.NewPointsClient(c.conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	pointIds := make([]*pb.PointId, len(items))
+	ids := make([]string, len(items))
 	for i, item := range items {
-		pointIds[i] = &pb.PointId{
-			PointIdOptions: &pb.PointId_Uuid{
-				Uuid: pathHash(item),
-			},
+		ids[i] = pathHash(item)
+	}
+
+	if err := c.store.Delete(ids); nil != err {
+		return err
+	}
+
+	c.facets.invalidate()
+	c.duplicates.invalidate()
+
+	return nil
+}
+
+func (c *serverContext) search(
+	ctx context.Context,
+	query string,
+	mode string,
+	arithmetic bool,
+	limit uint,
+	offset *uint,
+	cursor *string,
+	include []string,
+	collapse bool,
+	keepDuplicates bool,
+	filter *models.PhotoFilter,
+) (*models.PhotoResultsResponse, error) {
+	var position *resultsPosition
+	var v []float32
+	var text string
+	var terms []queryTerm
+	if !arithmetic {
+		terms = []queryTerm{{text: query, weight: 1}}
+	} else if "" != query || nil == cursor {
+		var err error
+		if terms, err = parseQuery(query); nil != err {
+			return nil, err
 		}
 	}
 
-	_, err := client.Delete(ctx, &pb.DeletePoints{
-		CollectionName: c.coll,
-		Points: &pb.PointsSelector{
-			PointsSelectorOneOf: &pb.PointsSelector_Points{
-				Points: &pb.PointsIdsList{
-					Ids: pointIds,
-				},
-			},
-		},
-	})
-	if nil != err {
-		code := status.Code(err)
-		glog.Errorf("Failed to delete points: %v; grpc code: %v", err, code)
+	switch mode {
+	case "", SEARCH_MODE_VECTOR:
+	case SEARCH_MODE_HYBRID:
+		// The fused scores of hybrid search can't be compared to similarities.
+		if nil != filter && nil != filter.MinScore {
+			return nil, InvalidSearchMode
+		}
+		text = positiveText(terms)
+	default:
+		return nil, InvalidSearchMode
+	}
+
+	if nil != cursor {
+		var err error
+		if position, err = parseResultsCursor(*cursor); nil != err {
+			return nil, err
+		}
+		filter = position.Filter
+		text = position.Text
+		v = c.queryVectors.get(position.VectorHash)
+	}
 
-		switch code {
-		case codes.Unavailable, codes.DeadlineExceeded:
-			return VectorDatabaseUnavailable
+	if nil == v {
+		if nil == terms {
+			// The query vector is no longer cached and can't be recomputed.
+			return nil, InvalidCursor
+		}
 
-		default:
-			return err
+		var err error
+		v, err = c.getQueryEmbedding(terms)
+		if nil != err {
+			glog.Errorf("Failed to get embedding for query '%s': %v", query, err)
+			return nil, err
 		}
 	}
 
-	return nil
+	hash := c.queryVectors.put(v)
+	if nil != position && hash != position.VectorHash {
+		return nil, InvalidCursor
+	}
+
+	return c.searchPage(ctx, limit, offset, position, include,
+		resultsPosition{VectorHash: hash, Text: text, Collapse: collapse,
+			KeepDuplicates: keepDuplicates, Filter: filter},
+		func(params searchParams) ([]*pb.ScoredPoint, error) {
+			glog.V(1).Infof("Search filter: %v", params.Filter)
+			if "" != text {
+				return c.hybridSearch(v, parseHybridQuery(text), params)
+			}
+			return c.store.Search(v, params)
+		})
 }
 
-func (c *serverContext) search(
-	query string,
+func (c *serverContext) recommend(
+	ctx context.Context,
+	examples models.RecommendExamples,
 	limit uint,
 	offset *uint,
+	cursor *string,
+	include []string,
 	filter *models.PhotoFilter,
 ) (*models.PhotoResultsResponse, error) {
-	v, err := c.getEmbedding(query)
+	var position *resultsPosition
+	var positiveHashes, negativeHashes []string
+	if nil != cursor {
+		var err error
+		if position, err = parseResultsCursor(*cursor); nil != err {
+			return nil, err
+		}
+		examples.Positive = position.Positive
+		examples.Negative = position.Negative
+		examples.Strategy = position.Strategy
+		positiveHashes = position.PositiveVectors
+		negativeHashes = position.NegativeVectors
+		filter = position.Filter
+	} else if len(examples.Positive)+len(examples.PositiveQueries) < 1 {
+		return nil, InvalidRecommendExamples
+	}
+
+	strategy, found := recommendStrategies[examples.Strategy]
+	if !found {
+		return nil, InvalidRecommendExamples
+	}
+
+	positiveVectors, positiveHashes, err := c.getQueryVectors(examples.PositiveQueries, positiveHashes)
+	if nil != err {
+		return nil, err
+	}
+	negativeVectors, negativeHashes, err := c.getQueryVectors(examples.NegativeQueries, negativeHashes)
 	if nil != err {
-		glog.Errorf("Failed to get embedding for query '%s': %v", query, err)
 		return nil, err
 	}
 
-	client := pb.NewPointsClient(c.conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	finalOffset := uint64(0)
-	if nil != offset {
-		finalOffset = uint64(*offset)
+	query := resultsPosition{
+		Positive:        examples.Positive,
+		Negative:        examples.Negative,
+		PositiveVectors: positiveHashes,
+		NegativeVectors: negativeHashes,
+		Strategy:        examples.Strategy,
+		Filter:          filter,
 	}
 
-	qdrantFilter := makeQdrantFilter(filter)
-	glog.V(1).Infof("Search filter: %v", qdrantFilter)
-
-	req := &pb.SearchPoints{
-		CollectionName: c.coll,
-		Vector:         v,
-		Limit:          uint64(limit),
-		Offset:         &finalOffset,
-		Filter:         qdrantFilter,
-		WithPayload: &pb.WithPayloadSelector{
-			SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true},
-		},
+	return c.searchPage(ctx, limit, offset, position, include, query,
+		func(params searchParams) ([]*pb.ScoredPoint, error) {
+			glog.V(1).Infof("Recommend filter: %v", params.Filter)
+			return c.store.Recommend(recommendExamples{
+				Positive:        examples.Positive,
+				Negative:        examples.Negative,
+				PositiveVectors: positiveVectors,
+				NegativeVectors: negativeVectors,
+				Strategy:        strategy,
+			}, params)
+		})
+}
+
+// getQueryVectors gets the embeddings of the given queries, along with their
+// hashes in the query vector cache. If the hashes are given, as they are when
+// continuing at a cursor, the vectors are taken from the cache instead, and
+// only calculated again from the queries if they are no longer cached.
+func (c *serverContext) getQueryVectors(queries, hashes []string) ([][]float32, []string, error) {
+	if nil == hashes {
+		hashes = make([]string, len(queries))
 	}
-	if nil != filter.MinScore {
-		req.ScoreThreshold = filter.MinScore
+
+	vectors := make([][]float32, len(hashes))
+	for i, hash := range hashes {
+		if "" != hash {
+			vectors[i] = c.queryVectors.get(hash)
+			if nil != vectors[i] {
+				continue
+			} else if i >= len(queries) {
+				// The query vector is no longer cached and can't be recomputed.
+				return nil, nil, InvalidCursor
+			}
+		}
+
+		v, err := c.getEmbedding(embeddingText(queries[i]))
+		if nil != err {
+			glog.Errorf("Failed to get embedding for query '%s': %v", queries[i], err)
+			return nil, nil, err
+		}
+
+		newHash := c.queryVectors.put(v)
+		if "" != hash && newHash != hash {
+			return nil, nil, InvalidCursor
+		}
+		hashes[i] = newHash
+		vectors[i] = v
 	}
 
-	r, err := client.Search(ctx, req)
+	return vectors, hashes, nil
+}
+
+// searchPage runs a similarity search for a page of results. With a position,
+// the page continues right after the position; otherwise it starts at the
+// given offset. The query describes the search for the cursor of the next
+// page, which is added to the response if there are more results.
+func (c *serverContext) searchPage(
+	ctx context.Context,
+	limit uint,
+	offset *uint,
+	position *resultsPosition,
+	include []string,
+	query resultsPosition,
+	run func(params searchParams) ([]*pb.ScoredPoint, error),
+) (*models.PhotoResultsResponse, error) {
+	params, err := c.makeSearchParams(ctx, limit, offset, query.Filter)
 	if nil != err {
-		code := status.Code(err)
-		glog.Errorf("Failed to search vectors: %v; grpc code: %v", err, code)
+		return nil, err
+	}
+	// Fetch one more result than requested to find out if there's another page.
+	params.Limit = uint64(limit) + 1
+	if nil != position {
+		// Also fetch the last results from the previous page again, which may
+		// have moved here when photos were removed from the index.
+		params.Offset = position.Count - min(position.Count, CURSOR_SLACK)
+		params.Limit += position.Count - params.Offset
+		query = *position
+	}
+	// Unless the results are fetched from the start, the first of them must
+	// have been returned before, or results may be skipped.
+	checkAnchor := nil != position && params.Offset > 0
+
+	page := make([]*pb.ScoredPoint, 0, limit)
+	var nextCursor *string
+	for {
+		r, err := run(params)
+		if nil != err {
+			return nil, err
+		}
 
-		switch code {
-		case codes.Unavailable, codes.DeadlineExceeded:
-			return nil, VectorDatabaseUnavailable
+		if checkAnchor && (len(r) < 1 || !position.isSeen(r[0])) {
+			// More results than the slack were removed before the position, so
+			// results not returned yet may have moved before the fetched ones.
+			glog.V(1).Infof("Cursor position %d is no longer anchored in the results.", position.Count)
+			return nil, InvalidCursor
+		}
+		checkAnchor = false
 
-		default:
-			return nil, err
+		var shared map[string]bool
+		if !query.KeepDuplicates {
+			if shared, err = c.getSharedContentHashes(ctx, r); nil != err {
+				return nil, err
+			}
+		}
+
+		for i, point := range r {
+			if nil != position && position.isSeen(point) {
+				continue
+			} else if query.isCollapsed(point) {
+				// Skip near-duplicates of results returned before.
+				query = query.next(point, params.Offset+uint64(i))
+				continue
+			} else if len(page) == int(limit) {
+				nextCursor = signCursor(query)
+				break
+			}
+
+			page = append(page, point)
+			query = query.next(point, params.Offset+uint64(i))
+			if hash := getContentHashFromPayload(point.Payload); shared[hash] {
+				// Skip the exact duplicates of this result from now on.
+				query.Hashes = appendRecent(query.Hashes, hash)
+			}
 		}
+
+		if nil != nextCursor || !query.skipsDuplicates() || uint64(len(r)) < params.Limit {
+			break
+		}
+
+		// Skipped duplicates left the page short, so fetch more results.
+		params.Offset += uint64(len(r))
+		params.Limit = uint64(limit) + 1
 	}
 
-	return makePhotoResultsResponse(r.Result), nil
+	result := makePhotoResultsResponse(page, include)
+	result.Cursor = nextCursor
+
+	return result, nil
 }
 
-func (c *serverContext) recommend(
-	id string,
+// browse pages through the photos ordered by their timestamp. Photos without
+// a timestamp are skipped.
+func (c *serverContext) browse(
+	ctx context.Context,
 	limit uint,
-	offset *uint,
+	cursor *string,
+	descending bool,
+	include []string,
 	filter *models.PhotoFilter,
 ) (*models.PhotoResultsResponse, error) {
-	client := pb.NewPointsClient(c.conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	qdrantFilter, err := c.makeFilter(ctx, filter)
+	if nil != err {
+		return nil, err
+	} else if nil == qdrantFilter {
+		qdrantFilter = &pb.Filter{}
+	}
 
-	finalOffset := uint64(0)
-	if nil != offset {
-		finalOffset = uint64(*offset)
+	direction := pb.Direction_Asc
+	if descending {
+		direction = pb.Direction_Desc
+	}
+	orderBy := &pb.OrderBy{
+		Key:       METADATA_TIMESTAMP,
+		Direction: &direction,
 	}
 
-	qdrantFilter := makeQdrantFilter(filter)
-	glog.V(1).Infof("Recommend filter: %v", qdrantFilter)
+	if nil != cursor {
+		position, err := parseBrowseCursor(*cursor)
+		if nil != err {
+			return nil, err
+		}
 
-	req := &pb.RecommendPoints{
-		CollectionName: c.coll,
-		Positive: []*pb.PointId{
-			{
-				PointIdOptions: &pb.PointId_Uuid{Uuid: id},
-			},
-		},
-		Limit:  uint64(limit),
-		Offset: &finalOffset,
-		Filter: qdrantFilter,
-		WithPayload: &pb.WithPayloadSelector{
-			SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true},
-		},
-	}
-	if nil != filter.MinScore {
-		req.ScoreThreshold = filter.MinScore
+		orderBy.StartFrom = &pb.StartFrom{
+			Value: &pb.StartFrom_Integer{Integer: position.Timestamp},
+		}
+		// Skip the photos with the same timestamp that were already returned.
+		qdrantFilter.MustNot = append(qdrantFilter.MustNot, makeHasIdCondition(position.Ids))
 	}
-	r, err := client.Recommend(ctx, req)
+	glog.V(1).Infof("Browse filter: %v", qdrantFilter)
+
+	// Get one more photo than requested to find out if there's another page.
+	points, _, err := c.store.Scroll(ctx, scrollParams{
+		PageSize: uint32(limit) + 1,
+		Filter:   qdrantFilter,
+		OrderBy:  orderBy,
+	})
 	if nil != err {
-		code := status.Code(err)
-		glog.Errorf("Failed to recommend similar for '%s': %v; grpc code: %v",
-			id, err, code)
+		return nil, err
+	}
 
-		switch code {
-		case codes.Unavailable, codes.DeadlineExceeded:
-			return nil, VectorDatabaseUnavailable
+	var nextCursor *string
+	if len(points) > int(limit) {
+		points = points[:limit]
+		nextCursor = makeBrowseCursor(points, cursor)
+	}
+
+	result := makeBrowseResultsResponse(points, include)
+	result.Cursor = nextCursor
+
+	return result, nil
+}
+
+func (c *serverContext) getPayloadById(id string) (map[string]*pb.Value, error) {
+	return c.store.GetPayload(id)
+}
 
-		default:
+// getPoints gets the points with the given IDs from the store, a page at a
+// time.
+func (c *serverContext) getPoints(
+	ctx context.Context,
+	ids []string,
+	fields ...string,
+) ([]*pb.RetrievedPoint, error) {
+	var result []*pb.RetrievedPoint
+	for batch := range slices.Chunk(ids, POINTS_PAGE_SIZE) {
+		points, err := c.store.GetPoints(ctx, batch, fields)
+		if nil != err {
 			return nil, err
 		}
+
+		result = append(result, points...)
 	}
 
-	return makePhotoResultsResponse(r.Result), nil
+	return result, nil
 }
 
-func (c *serverContext) getPayloadById(id string) (map[string]*pb.Value, error) {
-	client := pb.NewPointsClient(c.conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// getPhotoDetails gets the full metadata of the photo with the given ID, with
+// the EXIF tags limited to the ones allowed by the server's policy.
+func (c *serverContext) getPhotoDetails(id string) (*models.PhotoDetailsResponse, error) {
+	payload, err := c.getPayloadById(id)
+	if nil != err {
+		return nil, err
+	}
 
-	r, err := client.Get(ctx, &pb.GetPoints{
-		CollectionName: c.coll,
-		Ids: []*pb.PointId{
-			{
-				PointIdOptions: &pb.PointId_Uuid{Uuid: id},
-			},
-		},
-		WithPayload: &pb.WithPayloadSelector{
-			SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true},
-		},
-	})
+	details := &models.PhotoDetailsResponse{
+		Id:          id,
+		Path:        *getPathFromPayload(payload),
+		Timestamp:   getTimestampFromPayload(payload),
+		MediaType:   getMediaTypeFromPayload(payload),
+		Width:       getExifIntegerFromPayload(payload, EXIF_IMAGE_WIDTH, EXIF_EXIF_IMAGE_WIDTH),
+		Height:      getExifIntegerFromPayload(payload, EXIF_IMAGE_HEIGHT, EXIF_EXIF_IMAGE_HEIGHT),
+		Orientation: getOrientationFromPayload(payload),
+		Exif:        make(map[string]any),
+	}
+
+	fi, err := os.Stat(path.Join(c.photosRootDir, details.Path))
 	if nil != err {
-		code := status.Code(err)
-		glog.Errorf("Failed to get point details for '%s': %v; grpc code: %v",
-			id, err, code)
+		glog.Warningf("Failed to get file info for '%s': %v", details.Path, err)
+	} else {
+		size := fi.Size()
+		details.FileSize = &size
+	}
 
-		switch code {
-		case codes.Unavailable, codes.DeadlineExceeded:
-			return nil, VectorDatabaseUnavailable
+	if c.exifTags.allowsLocation() {
+		details.Location = getLocationFromPayload(payload)
+		details.Place = getPlaceFromPayload(payload)
+	}
+	details.Tags = getTagsFromPayload(payload)
 
-		default:
-			return nil, err
+	for tag, value := range payload[METADATA_EXIF].GetStructValue().GetFields() {
+		if c.exifTags.allows(tag) {
+			details.Exif[tag] = renderExifValue(tag, value)
 		}
 	}
 
-	return r.Result[0].Payload, nil
+	return details, nil
 }
 
 func (c *serverContext) getEmbedding(query string) ([]float32, error) {
@@ -477,19 +752,97 @@ func (c *serverContext) getEmbedding(query string) ([]float32, error) {
 	return respBody.Vector, nil
 }
 
+// isValidMediaType checks if the given media type of an item to index is
+// known; an empty media type stands for photos.
+func isValidMediaType(mediaType string) bool {
+	return "" == mediaType || MEDIA_TYPE_PHOTO == mediaType || MEDIA_TYPE_VIDEO == mediaType
+}
+
 func pathHash(path string) string {
 	hash := sha1.Sum([]byte(path))
 	// hash is 20 bytes, but we need 16 bytes to mimic a UUID; take the last 16 bytes
 	return hex.EncodeToString(hash[4:])
 }
 
-func makePhotoResultsResponse(scoredItems []*pb.ScoredPoint) *models.PhotoResultsResponse {
+func (c *serverContext) makeSearchParams(
+	ctx context.Context,
+	limit uint,
+	offset *uint,
+	filter *models.PhotoFilter,
+) (searchParams, error) {
+	qdrantFilter, err := c.makeFilter(ctx, filter)
+	if nil != err {
+		return searchParams{}, err
+	}
+
+	params := searchParams{
+		Limit:  uint64(limit),
+		Filter: qdrantFilter,
+	}
+
+	if nil != offset {
+		params.Offset = uint64(*offset)
+	}
+	if nil != filter {
+		params.ScoreThreshold = filter.MinScore
+	}
+
+	return params, nil
+}
+
+// makeFilter makes the qdrant filter for the given filter, including the
+// conditions which need to look up state kept by the server, like albums.
+func (c *serverContext) makeFilter(ctx context.Context, filter *models.PhotoFilter) (*pb.Filter, error) {
+	qdrantFilter := makeQdrantFilter(filter)
+	if nil == filter {
+		return qdrantFilter, nil
+	}
+
+	var must []*pb.Condition
+	if len(filter.Tags) > 0 {
+		if "" != filter.TagsMode && TAGS_MODE_ANY != filter.TagsMode && TAGS_MODE_ALL != filter.TagsMode {
+			return nil, InvalidTags
+		}
+
+		tags, err := normalizeTags(filter.Tags)
+		if nil != err {
+			return nil, err
+		}
+		must = append(must, makeTagsCondition(tags, filter.TagsMode))
+	}
+
+	if nil != filter.AlbumId {
+		condition, err := c.makeAlbumCondition(*filter.AlbumId)
+		if nil != err {
+			return nil, err
+		}
+		must = append(must, condition)
+	}
+
+	if filter.FavoritesOnly || nil != filter.MinRating {
+		condition, err := c.makeRatingsCondition(ctx, filter.FavoritesOnly, filter.MinRating)
+		if nil != err {
+			return nil, err
+		}
+		must = append(must, condition)
+	}
+
+	if len(must) > 0 {
+		if nil == qdrantFilter {
+			qdrantFilter = &pb.Filter{}
+		}
+		qdrantFilter.Must = append(qdrantFilter.Must, must...)
+	}
+
+	return qdrantFilter, nil
+}
+
+func makePhotoResultsResponse(scoredItems []*pb.ScoredPoint, include []string) *models.PhotoResultsResponse {
 	items := make([]*models.PhotoResultItem, len(scoredItems))
 	for i, r := range scoredItems {
-		items[i] = &models.PhotoResultItem{
-			Id:        r.Id.GetUuid(),
-			Path:      *getPathFromPayload(r.Payload),
-			Timestamp: getTimestampFromPayload(r.Payload),
+		items[i] = makePhotoResultItem(r.Id, r.Payload, include)
+		if slices.Contains(include, INCLUDE_SCORE) {
+			items[i].Score = &r.Score
 		}
 	}
 
@@ -500,12 +853,62 @@ func makePhotoResultsResponse(scoredItems []*pb.ScoredPoint) *models.PhotoResult
 	return result
 }
 
+func makeBrowseResultsResponse(points []*pb.RetrievedPoint, include []string) *models.PhotoResultsResponse {
+	items := make([]*models.PhotoResultItem, len(points))
+	for i, p := range points {
+		items[i] = makePhotoResultItem(p.Id, p.Payload, include)
+	}
+
+	return &models.PhotoResultsResponse{
+		Items: items,
+	}
+}
+
+// makePhotoResultItem makes a result item for the given point, including the
+// optional metadata requested by the client.
+func makePhotoResultItem(id *pb.PointId, payload map[string]*pb.Value, include []string) *models.PhotoResultItem {
+	item := &models.PhotoResultItem{
+		Id:        id.GetUuid(),
+		Path:      *getPathFromPayload(payload),
+		Timestamp: getTimestampFromPayload(payload),
+		MediaType: getMediaTypeFromPayload(payload),
+	}
+
+	if slices.Contains(include, INCLUDE_DIMENSIONS) {
+		item.Width = getExifIntegerFromPayload(payload, EXIF_IMAGE_WIDTH, EXIF_EXIF_IMAGE_WIDTH)
+		item.Height = getExifIntegerFromPayload(payload, EXIF_IMAGE_HEIGHT, EXIF_EXIF_IMAGE_HEIGHT)
+	}
+
+	if slices.Contains(include, INCLUDE_ORIENTATION) {
+		item.Orientation = getOrientationFromPayload(payload)
+	}
+
+	if slices.Contains(include, INCLUDE_EXIF) {
+		item.Exif = make(map[string]any)
+		for _, tag := range resultExifTags {
+			if field := getExifFieldFromPayload(payload, tag); nil != field {
+				item.Exif[tag] = payloadValueToAny(field)
+			}
+		}
+	}
+
+	return item
+}
+
 func exifTagsToPayloadFields(tags map[string]any) map[string]*pb.Value {
 	result := make(map[string]*pb.Value)
 	for k, v := range tags {
+		if slices.Contains(exifNumericRationalTags, k) {
+			if f, ok := exifRationalToFloat(v); ok {
+				result[k] = &pb.Value{Kind: &pb.Value_DoubleValue{DoubleValue: f}}
+				continue
+			}
+		}
+
 		val, err := exifTagValueToFieldValue(v)
 		if nil != err {
 			glog.Errorf("Failed to convert value '%v' to qdrant field value: %v", v, err)
+			continue
 		}
 		result[k] = val
 	}
@@ -519,7 +922,7 @@ func exifTagValueToFieldValue(v any) (*pb.Value, error) {
 		return &pb.Value{Kind: &pb.Value_BoolValue{BoolValue: val}}, nil
 
 	case json.Number:
-		if strings.Index(string(val), ".") >= 0 {
+		if strings.ContainsAny(string(val), ".eE") {
 			// float64
 			f, err := val.Float64()
 			if nil == err {
@@ -547,7 +950,9 @@ func exifTagValueToFieldValue(v any) (*pb.Value, error) {
 			}
 			values[i] = tmp
 		}
-		return &pb.Value{Kind: &pb.Value_ListValue{}}, nil
+		return &pb.Value{Kind: &pb.Value_ListValue{
+			ListValue: &pb.ListValue{Values: values},
+		}}, nil
 
 	case nil:
 		return &pb.Value{Kind: &pb.Value_NullValue{NullValue: pb.NullValue_NULL_VALUE}}, nil
@@ -558,6 +963,32 @@ func exifTagValueToFieldValue(v any) (*pb.Value, error) {
 	}
 }
 
+// exifRationalToFloat converts a rational EXIF value, represented as a pair of
+// numerator and denominator, to a float.
+func exifRationalToFloat(v any) (float64, bool) {
+	pair, ok := v.([]any)
+	if !ok || len(pair) != 2 {
+		return 0, false
+	}
+
+	num, numOk := pair[0].(json.Number)
+	den, denOk := pair[1].(json.Number)
+	if !numOk || !denOk {
+		return 0, false
+	}
+
+	n, err := num.Float64()
+	if nil != err {
+		return 0, false
+	}
+	d, err := den.Float64()
+	if nil != err || 0 == d {
+		return 0, false
+	}
+
+	return n / d, true
+}
+
 func getPathFromPayload(payload map[string]*pb.Value) *string {
 	path := payload[METADATA_PATH].GetStringValue()
 	return &path
@@ -573,6 +1004,16 @@ func getTimestampFromPayload(payload map[string]*pb.Value) *int64 {
 	return &val
 }
 
+func getMediaTypeFromPayload(payload map[string]*pb.Value) string {
+	mediaType, found := payload[METADATA_MEDIA_TYPE]
+	if !found {
+		// Items indexed before media types were introduced are all photos.
+		return MEDIA_TYPE_PHOTO
+	}
+
+	return mediaType.GetStringValue()
+}
+
 func getOrientationFromPayload(payload map[string]*pb.Value) *int64 {
 	field := getExifFieldFromPayload(payload, EXIF_ORIENTATION)
 	if nil == field {
@@ -593,9 +1034,27 @@ func getOrientationFromPayload(payload map[string]*pb.Value) *int64 {
 	return nil
 }
 
+// getExifIntegerFromPayload gets the integer value of the first of the given
+// EXIF tags found in the payload.
+func getExifIntegerFromPayload(payload map[string]*pb.Value, fieldNames ...string) *int64 {
+	for _, fieldName := range fieldNames {
+		field := getExifFieldFromPayload(payload, fieldName)
+		if nil == field {
+			continue
+		}
+
+		if f, ok := getNumericValue(field); ok {
+			val := int64(f)
+			return &val
+		}
+	}
+
+	return nil
+}
+
 func getExifFieldFromPayload(payload map[string]*pb.Value, fieldName string) *pb.Value {
 	exif := payload[METADATA_EXIF].GetStructValue()
-	field, found := exif.Fields[fieldName]
+	field, found := exif.GetFields()[fieldName]
 	if !found {
 		return nil
 	}
@@ -666,6 +1125,76 @@ func makeQdrantFilter(filter *models.PhotoFilter) *pb.Filter {
 		}
 	}
 
+	if nil != filter.CameraMake {
+		must = append(must, makeKeywordCondition(exifKey(EXIF_CAMERA_MAKE), *filter.CameraMake))
+	}
+
+	if nil != filter.CameraModel {
+		must = append(must, makeKeywordCondition(exifKey(EXIF_CAMERA_Model), *filter.CameraModel))
+	}
+
+	if nil != filter.Lens {
+		must = append(must, makeKeywordCondition(exifKey(EXIF_LENS_MODEL), *filter.Lens))
+	}
+
+	if nil != filter.MinIso || nil != filter.MaxIso {
+		must = append(must, makeRangeCondition(exifKey(EXIF_ISO), filter.MinIso, filter.MaxIso))
+	}
+
+	if nil != filter.MinAperture || nil != filter.MaxAperture {
+		must = append(must, makeRangeCondition(exifKey(EXIF_F_NUMBER),
+			filter.MinAperture, filter.MaxAperture))
+	}
+
+	if nil != filter.MinFocalLength || nil != filter.MaxFocalLength {
+		must = append(must, makeRangeCondition(exifKey(EXIF_FOCAL_LENGTH),
+			filter.MinFocalLength, filter.MaxFocalLength))
+	}
+
+	if nil != filter.BoundingBox {
+		must = append(must, makeGeoBoundingBoxCondition(filter.BoundingBox))
+	}
+
+	if nil != filter.Near {
+		must = append(must, makeGeoRadiusCondition(filter.Near))
+	}
+
+	if len(filter.Places) > 0 {
+		must = append(must, makePlacesCondition(filter.Places))
+	}
+
+	if len(filter.MediaTypes) > 0 {
+		mediaTypeFilter := []*pb.Condition{
+			{
+				ConditionOneOf: &pb.Condition_Field{
+					Field: &pb.FieldCondition{
+						Key: METADATA_MEDIA_TYPE,
+						Match: &pb.Match{
+							MatchValue: &pb.Match_Keywords{
+								Keywords: &pb.RepeatedStrings{Strings: filter.MediaTypes},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		if slices.Contains(filter.MediaTypes, MEDIA_TYPE_PHOTO) {
+			// Items indexed before media types were introduced are all photos.
+			mediaTypeFilter = append(mediaTypeFilter, &pb.Condition{
+				ConditionOneOf: &pb.Condition_IsEmpty{
+					IsEmpty: &pb.IsEmptyCondition{Key: METADATA_MEDIA_TYPE},
+				},
+			})
+		}
+
+		must = append(must, &pb.Condition{
+			ConditionOneOf: &pb.Condition_Filter{
+				Filter: &pb.Filter{Should: mediaTypeFilter},
+			},
+		})
+	}
+
 	if len(must) > 0 || len(should) > 0 {
 		return &pb.Filter{
 			Must:   must,
@@ -675,3 +1204,85 @@ func makeQdrantFilter(filter *models.PhotoFilter) *pb.Filter {
 
 	return nil
 }
+
+// exifKey gets the payload key of the given EXIF tag.
+func exifKey(tag string) string {
+	return METADATA_EXIF + "." + tag
+}
+
+func makeKeywordCondition(key, value string) *pb.Condition {
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Field{
+			Field: &pb.FieldCondition{
+				Key: key,
+				Match: &pb.Match{
+					MatchValue: &pb.Match_Keyword{Keyword: value},
+				},
+			},
+		},
+	}
+}
+
+// makeRangeCondition makes a condition for values in the (inclusive) range
+// [min, max]; either bound may be nil.
+func makeRangeCondition(key string, min, max *float64) *pb.Condition {
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Field{
+			Field: &pb.FieldCondition{
+				Key: key,
+				Range: &pb.Range{
+					Gte: min,
+					Lte: max,
+				},
+			},
+		},
+	}
+}
+
+func makeHasIdCondition(ids []string) *pb.Condition {
+	pointIds := make([]*pb.PointId, len(ids))
+	for i, id := range ids {
+		pointIds[i] = makePointId(id)
+	}
+
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_HasId{
+			HasId: &pb.HasIdCondition{HasId: pointIds},
+		},
+	}
+}
+
+// payloadValueToAny converts a payload value to its plain Go representation,
+// as used for encoding it to JSON.
+func payloadValueToAny(v *pb.Value) any {
+	switch val := v.Kind.(type) {
+	case *pb.Value_BoolValue:
+		return val.BoolValue
+
+	case *pb.Value_IntegerValue:
+		return val.IntegerValue
+
+	case *pb.Value_DoubleValue:
+		return val.DoubleValue
+
+	case *pb.Value_StringValue:
+		return val.StringValue
+
+	case *pb.Value_ListValue:
+		values := make([]any, len(val.ListValue.GetValues()))
+		for i, value := range val.ListValue.GetValues() {
+			values[i] = payloadValueToAny(value)
+		}
+		return values
+
+	case *pb.Value_StructValue:
+		fields := make(map[string]any, len(val.StructValue.GetFields()))
+		for k, value := range val.StructValue.GetFields() {
+			fields[k] = payloadValueToAny(value)
+		}
+		return fields
+
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestUpsertMediaType(t *testing.T) {
+	c := &serverContext{
+		store:      newTestMemoryStore(t, filepath.Join(t.TempDir(), "photos.db")),
+		facets:     &facetsCache{},
+		duplicates: &duplicatesState{},
+	}
+
+	mediaType := func(s string) *string { return &s }
+	tests := []struct {
+		path      string
+		mediaType *string
+		expected  string
+		err       error
+	}{
+		{"a.jpg", nil, MEDIA_TYPE_PHOTO, nil},
+		{"b.mp4", nil, MEDIA_TYPE_VIDEO, nil},
+		{"c.mp4", mediaType(""), MEDIA_TYPE_PHOTO, nil},
+		{"d.jpg", mediaType(MEDIA_TYPE_VIDEO), MEDIA_TYPE_VIDEO, nil},
+		{"e.jpg", mediaType("audio"), "", InvalidMediaType},
+		{"f.jpg", mediaType("Photo"), "", InvalidMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			err := c.upsert([]*models.ItemToIndex{{
+				Payload: models.ItemPayload{Path: test.path, MediaType: test.mediaType},
+				Vector:  testVector(0, 1),
+			}})
+			if test.err != err {
+				t.Fatalf("upsert() error = %v, expected %v", err, test.err)
+			} else if nil != err {
+				return
+			}
+
+			payload, err := c.store.GetPayload(pathHash(test.path))
+			if nil != err {
+				t.Fatalf("GetPayload() failed: %v", err)
+			}
+			if actual := payload[METADATA_MEDIA_TYPE].GetStringValue(); test.expected != actual {
+				t.Errorf("media type = %q, expected %q", actual, test.expected)
+			}
+		})
+	}
+}
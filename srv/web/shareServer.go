@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	// SHARE_THUMBNAIL_WIDTH is the width of the thumbnails on the page of a
+	// share.
+	SHARE_THUMBNAIL_WIDTH = 320
+	// SHARED_PHOTO_CACHE_CONTROL keeps shared photos out of shared caches, and
+	// out of the browser's cache once the share is revoked.
+	SHARED_PHOTO_CACHE_CONTROL = "private, no-store"
+)
+
+// sharePageTemplate renders the page of a share, which shows the thumbnails of
+// the shared photos linking to the photos, or asks for the password.
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{if .Name}}{{.Name}}{{else}}Shared photos{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+main { display: flex; flex-wrap: wrap; gap: 4px; }
+main img { height: 160px; }
+</style>
+</head>
+<body>
+{{if .PasswordRequired}}
+<form method="post" action="/s/{{.Token}}/unlock">
+<p>These photos are protected by a password.</p>
+{{if .WrongPassword}}<p>The password is wrong.</p>{{end}}
+<input type="password" name="password" autofocus>
+<button type="submit">View</button>
+</form>
+{{else}}
+<h1>{{if .Name}}{{.Name}}{{else}}Shared photos{{end}}</h1>
+<main>
+{{range .Items}}<a href="/s/{{$.Token}}/photos/{{.Id}}"><img src="/s/{{$.Token}}/photos/{{.Id}}/{{$.Width}}" loading="lazy" alt=""></a>
+{{end}}</main>
+{{end}}
+</body>
+</html>
+`))
+
+type sharePage struct {
+	Token            string
+	Width            int
+	PasswordRequired bool
+	WrongPassword    bool
+	*models.SharedPhotosResponse
+}
+
+// shareServerContext serves the photos of shares to anonymous users, who can
+// only view the shared photos and their thumbnails.
+type shareServerContext struct {
+	publicServerContext
+}
+
+func (c shareServerContext) addShareRoutes(mux *mux.Router) {
+	mux.HandleFunc("", c.handleGetSharePage).
+		Methods("GET")
+
+	mux.HandleFunc("/unlock", c.handleUnlockShare).
+		Methods("POST")
+
+	mux.HandleFunc("/photos", c.handleGetSharedPhotos).
+		Methods("GET")
+
+	mux.HandleFunc("/photos/{id}", c.handleGetSharedPhoto).
+		Methods("GET")
+
+	mux.HandleFunc("/photos/{id}/{width}", c.handleGetSharedPhotoWithWidth).
+		Methods("GET")
+}
+
+func (c shareServerContext) handleGetSharePage(w http.ResponseWriter, r *http.Request) {
+	c.renderSharePage(w, r, false)
+}
+
+func (c shareServerContext) renderSharePage(w http.ResponseWriter, r *http.Request, wrongPassword bool) {
+	token := mux.Vars(r)["token"]
+
+	w.Header().Add("cache-control", "no-store")
+
+	sh, err := c.getShareForToken(token)
+	if nil != err {
+		http.Error(w, "These photos are no longer shared.", http.StatusNotFound)
+		return
+	}
+
+	page := sharePage{Token: token, Width: SHARE_THUMBNAIL_WIDTH}
+	status := http.StatusOK
+	if !c.isShareUnlocked(sh, r) {
+		page.PasswordRequired = true
+		page.WrongPassword = wrongPassword
+		page.SharedPhotosResponse = &models.SharedPhotosResponse{}
+		status = http.StatusUnauthorized
+	} else if page.SharedPhotosResponse, err = c.getSharedPhotos(r.Context(), sh); nil != err {
+		glog.Errorf("Failed to get photos of share '%s': %v", sh.Id, err)
+		http.Error(w, "Failed to get the shared photos.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := sharePageTemplate.Execute(w, page); nil != err {
+		glog.Errorf("Failed to render page of share '%s': %v", sh.Id, err)
+	}
+}
+
+// handleUnlockShare checks the password posted in the 'password' form field,
+// and sets the cookie proving it was given before redirecting to the share's
+// page.
+func (c shareServerContext) handleUnlockShare(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	sh, err := c.getShareForToken(token)
+	if nil != err {
+		http.Error(w, "These photos are no longer shared.", http.StatusNotFound)
+		return
+	} else if !sh.HasPassword {
+		http.Redirect(w, r, "/s/"+token, http.StatusSeeOther)
+		return
+	}
+
+	secure := nil != r.TLS || "https" == r.Header.Get("X-Forwarded-Proto")
+	cookie, err := c.unlockShare(r.Context(), sh, token, r.PostFormValue("password"), secure)
+	if errors.Is(err, TooManyUnlockAttempts) {
+		glog.Warningf("Too many attempts to unlock share '%s'.", sh.Id)
+		http.Error(w, "Too many wrong passwords, please try again later.", http.StatusTooManyRequests)
+		return
+	} else if nil != err {
+		glog.V(1).Infof("Failed to unlock share '%s': %v", sh.Id, err)
+		c.renderSharePage(w, r, true)
+		return
+	}
+
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, "/s/"+token, http.StatusSeeOther)
+}
+
+func (c shareServerContext) handleGetSharedPhotos(w http.ResponseWriter, r *http.Request) {
+	sh, ok := c.authorizeShare(w, r, "")
+	if !ok {
+		return
+	}
+
+	res, err := c.getSharedPhotos(r.Context(), sh)
+	if nil != err {
+		c.respondForError(err, w)
+	} else {
+		w.Header().Add("content-type", "application/json; charset=utf-8")
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (c shareServerContext) handleGetSharedPhoto(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.authorizeShare(w, r, mux.Vars(r)["id"]); ok {
+		c.handleV1PhotosGetById(w, r)
+	}
+}
+
+func (c shareServerContext) handleGetSharedPhotoWithWidth(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.authorizeShare(w, r, mux.Vars(r)["id"]); ok {
+		c.handleV1PhotosWithWidthGetById(w, r)
+	}
+}
+
+// authorizeShare checks that the request's token grants access to a share,
+// which is unlocked and includes the photo with the given ID, if any. If not,
+// it responds with the error.
+func (c shareServerContext) authorizeShare(w http.ResponseWriter, r *http.Request, id string) (*share, bool) {
+	sh, err := c.getShareForToken(mux.Vars(r)["token"])
+	if nil != err {
+		c.respondForError(err, w)
+		return nil, false
+	} else if !c.isShareUnlocked(sh, r) {
+		c.respondForError(SharePasswordRequired, w)
+		return nil, false
+	} else if "" != id && !c.isSharedPhoto(sh, id) {
+		c.respondForError(PhotoNotFound, w)
+		return nil, false
+	}
+
+	return sh, true
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rokeller/photo-search/srv/web/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// SHARE_ID_SIZE is the number of random bytes in share IDs.
+	SHARE_ID_SIZE       = 8
+	SHARE_DEFAULT_TTL   = 7 * 24 * time.Hour
+	SHARE_MAX_TTL       = 365 * 24 * time.Hour
+	SHARE_COOKIE_PREFIX = "share_"
+
+	SHARE_PASSWORD_SALT_SIZE  = 16
+	SHARE_PASSWORD_ITERATIONS = 600000
+	SHARE_PASSWORD_KEY_SIZE   = 32
+
+	// The purposes of share tokens, which keep tokens of one purpose from
+	// being used for another.
+	SHARE_TOKEN_PURPOSE_ACCESS = "share"
+	SHARE_TOKEN_PURPOSE_UNLOCK = "unlock"
+
+	// SHARE_UNLOCK_CONCURRENCY is the number of share passwords hashed at the
+	// same time.
+	SHARE_UNLOCK_CONCURRENCY = 2
+	// SHARE_UNLOCK_MAX_FAILURES is the number of wrong passwords accepted for a
+	// share within SHARE_UNLOCK_WINDOW.
+	SHARE_UNLOCK_MAX_FAILURES = 5
+	SHARE_UNLOCK_WINDOW       = time.Minute
+)
+
+// share is a share as kept in the local store, along with its owner and the
+// hash of its password, if any.
+type share struct {
+	models.Share
+	Owner        string `json:"owner"`
+	PasswordSalt []byte `json:"salt,omitempty"`
+	PasswordHash []byte `json:"hash,omitempty"`
+}
+
+// shareToken is the signed token granting access to a share until it expires
+// or is revoked. Tokens for unlocking prove that the password of the share was
+// given, and are kept in a cookie.
+type shareToken struct {
+	Purpose string `json:"purpose"`
+	Id      string `json:"share"`
+	Expires int64  `json:"exp"`
+}
+
+// shareUnlockThrottle limits the attempts to unlock shares, which are costly
+// due to hashing the password, and could be used to guess it.
+type shareUnlockThrottle struct {
+	slots chan struct{}
+
+	mutex sync.Mutex
+	// failures holds the recent failed attempts by share ID.
+	failures map[string]*shareUnlockFailures
+}
+
+type shareUnlockFailures struct {
+	count int
+	since time.Time
+}
+
+func newShareUnlockThrottle() *shareUnlockThrottle {
+	return &shareUnlockThrottle{
+		slots:    make(chan struct{}, SHARE_UNLOCK_CONCURRENCY),
+		failures: make(map[string]*shareUnlockFailures),
+	}
+}
+
+// acquire waits for a slot to hash a password of the given share, unless it
+// had too many failed attempts recently.
+func (t *shareUnlockThrottle) acquire(ctx context.Context, id string) error {
+	t.mutex.Lock()
+	f, found := t.failures[id]
+	blocked := found && time.Since(f.since) < SHARE_UNLOCK_WINDOW && f.count >= SHARE_UNLOCK_MAX_FAILURES
+	t.mutex.Unlock()
+
+	if blocked {
+		return TooManyUnlockAttempts
+	}
+
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release releases the slot, and records if the attempt failed.
+func (t *shareUnlockThrottle) release(id string, failed bool) {
+	<-t.slots
+
+	if !failed {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	f, found := t.failures[id]
+	if !found || now.Sub(f.since) >= SHARE_UNLOCK_WINDOW {
+		// Forget about failures which don't count anymore.
+		for otherId, other := range t.failures {
+			if now.Sub(other.since) >= SHARE_UNLOCK_WINDOW {
+				delete(t.failures, otherId)
+			}
+		}
+
+		f = &shareUnlockFailures{since: now}
+		t.failures[id] = f
+	}
+	f.count++
+}
+
+func (s *localStore) getShare(id string) (*share, error) {
+	sh := &share{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(SHARES_BUCKET)).Get([]byte(id))
+		if nil == v {
+			return ShareNotFound
+		}
+
+		return json.Unmarshal(v, sh)
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	return sh, nil
+}
+
+func (s *localStore) putShare(sh *share) error {
+	v, err := json.Marshal(sh)
+	if nil != err {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(SHARES_BUCKET)).Put([]byte(sh.Id), v)
+	})
+}
+
+// listShares lists the shares of the given owner which have not expired yet,
+// ordered by descending creation time.
+func (s *localStore) listShares(owner string) ([]*share, error) {
+	now := time.Now().Unix()
+	shares := []*share{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(SHARES_BUCKET)).ForEach(func(_, v []byte) error {
+			sh := &share{}
+			if err := json.Unmarshal(v, sh); nil != err {
+				return err
+			}
+
+			if owner == sh.Owner && sh.Expires > now {
+				shares = append(shares, sh)
+			}
+
+			return nil
+		})
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].Created != shares[j].Created {
+			return shares[i].Created > shares[j].Created
+		}
+		return shares[i].Id < shares[j].Id
+	})
+
+	return shares, nil
+}
+
+// deleteShare deletes the share with the given ID, if it's owned by the given
+// owner.
+func (s *localStore) deleteShare(owner, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(SHARES_BUCKET))
+		v := bucket.Get([]byte(id))
+		if nil == v {
+			return ShareNotFound
+		}
+
+		sh := &share{}
+		if err := json.Unmarshal(v, sh); nil != err {
+			return err
+		} else if owner != sh.Owner {
+			return ShareNotFound
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// createShare creates a share of an album or of a list of photos, owned by
+// the authenticated subject.
+func (c *serverContext) createShare(ctx context.Context, req *models.ShareRequest) (*models.Share, error) {
+	owner := getSubject(ctx)
+	if "" == owner {
+		return nil, NotAuthenticated
+	} else if (nil == req.AlbumId) == (len(req.PhotoIds) < 1) {
+		return nil, InvalidShare
+	}
+
+	ttl := SHARE_DEFAULT_TTL
+	if nil != req.ExpiresIn {
+		ttl = time.Duration(*req.ExpiresIn) * time.Second
+		if ttl <= 0 || ttl > SHARE_MAX_TTL {
+			return nil, InvalidShare
+		}
+	}
+
+	if nil != req.AlbumId {
+		if _, err := c.local.getAlbum(*req.AlbumId); nil != err {
+			return nil, err
+		}
+	}
+	for _, id := range req.PhotoIds {
+		if _, err := c.store.GetPayload(id); nil != err {
+			return nil, err
+		}
+	}
+
+	id := make([]byte, SHARE_ID_SIZE)
+	if _, err := rand.Read(id); nil != err {
+		return nil, err
+	}
+
+	now := time.Now()
+	sh := &share{
+		Share: models.Share{
+			Id:       hex.EncodeToString(id),
+			AlbumId:  req.AlbumId,
+			PhotoIds: uniqueIds(req.PhotoIds),
+			Created:  now.Unix(),
+			Expires:  now.Add(ttl).Unix(),
+		},
+		Owner: owner,
+	}
+
+	if nil != req.Password && "" != *req.Password {
+		sh.PasswordSalt = make([]byte, SHARE_PASSWORD_SALT_SIZE)
+		if _, err := rand.Read(sh.PasswordSalt); nil != err {
+			return nil, err
+		}
+
+		hash, err := hashSharePassword(*req.Password, sh.PasswordSalt)
+		if nil != err {
+			return nil, err
+		}
+		sh.PasswordHash = hash
+		sh.HasPassword = true
+	}
+
+	if err := c.local.putShare(sh); nil != err {
+		return nil, err
+	}
+
+	glog.Infof("Subject '%s' created share '%s', expiring at %v.", owner, sh.Id, now.Add(ttl))
+
+	return c.local.makeShareResponse(sh), nil
+}
+
+func (c *serverContext) listShares(ctx context.Context) (*models.SharesResponse, error) {
+	owner := getSubject(ctx)
+	if "" == owner {
+		return nil, NotAuthenticated
+	}
+
+	shares, err := c.local.listShares(owner)
+	if nil != err {
+		return nil, err
+	}
+
+	result := &models.SharesResponse{Shares: make([]*models.Share, len(shares))}
+	for i, sh := range shares {
+		result.Shares[i] = c.local.makeShareResponse(sh)
+	}
+
+	return result, nil
+}
+
+// revokeShare revokes the share with the given ID, which must be owned by the
+// authenticated subject.
+func (c *serverContext) revokeShare(ctx context.Context, id string) error {
+	owner := getSubject(ctx)
+	if "" == owner {
+		return NotAuthenticated
+	}
+
+	if err := c.local.deleteShare(owner, id); nil != err {
+		return err
+	}
+
+	glog.Infof("Subject '%s' revoked share '%s'.", owner, id)
+
+	return nil
+}
+
+func (s *localStore) makeShareResponse(sh *share) *models.Share {
+	result := sh.Share
+	result.Token = *signWithKey(s.shareSigningKey, shareToken{
+		Purpose: SHARE_TOKEN_PURPOSE_ACCESS,
+		Id:      sh.Id,
+		Expires: sh.Expires,
+	})
+	result.Url = "/s/" + result.Token
+
+	return &result
+}
+
+func hashSharePassword(password string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, SHARE_PASSWORD_ITERATIONS, SHARE_PASSWORD_KEY_SIZE)
+}
+
+// getShareForToken gets the share the given token grants access to, as long
+// as it's neither expired nor revoked.
+func (c *serverContext) getShareForToken(token string) (*share, error) {
+	var t shareToken
+	if err := verifyWithKey(c.local.shareSigningKey, token, &t); nil != err || SHARE_TOKEN_PURPOSE_ACCESS != t.Purpose || "" == t.Id {
+		return nil, ShareNotFound
+	} else if t.Expires <= time.Now().Unix() {
+		return nil, ShareNotFound
+	}
+
+	sh, err := c.local.getShare(t.Id)
+	if nil != err {
+		return nil, err
+	} else if sh.Expires != t.Expires {
+		return nil, ShareNotFound
+	}
+
+	return sh, nil
+}
+
+// isShareUnlocked checks if the share needs no password, or if the request
+// carries the cookie proving the password was given.
+func (c *serverContext) isShareUnlocked(sh *share, r *http.Request) bool {
+	if !sh.HasPassword {
+		return true
+	}
+
+	cookie, err := r.Cookie(SHARE_COOKIE_PREFIX + sh.Id)
+	if nil != err {
+		return false
+	}
+
+	var t shareToken
+	err = verifyWithKey(c.local.shareSigningKey, cookie.Value, &t)
+
+	return nil == err && SHARE_TOKEN_PURPOSE_UNLOCK == t.Purpose && sh.Id == t.Id && sh.Expires == t.Expires
+}
+
+// unlockShare checks the password of a share, and makes the cookie proving
+// the password was given. Attempts are throttled per share.
+func (c *serverContext) unlockShare(
+	ctx context.Context,
+	sh *share,
+	token, password string,
+	secure bool,
+) (*http.Cookie, error) {
+	if err := c.shareUnlocks.acquire(ctx, sh.Id); nil != err {
+		return nil, err
+	}
+
+	hash, err := hashSharePassword(password, sh.PasswordSalt)
+	wrong := nil == err && 1 != subtle.ConstantTimeCompare(hash, sh.PasswordHash)
+	c.shareUnlocks.release(sh.Id, wrong)
+	if nil != err {
+		return nil, err
+	} else if wrong {
+		return nil, WrongSharePassword
+	}
+
+	return &http.Cookie{
+		Name: SHARE_COOKIE_PREFIX + sh.Id,
+		Value: *signWithKey(c.local.shareSigningKey, shareToken{
+			Purpose: SHARE_TOKEN_PURPOSE_UNLOCK,
+			Id:      sh.Id,
+			Expires: sh.Expires,
+		}),
+		Path:     "/s/" + token,
+		Expires:  time.Unix(sh.Expires, 0),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+// getSharedPhotos gets the photos of a share, in the order of the album or
+// the list of photos. Photos which were removed from the index are skipped.
+func (c *serverContext) getSharedPhotos(ctx context.Context, sh *share) (*models.SharedPhotosResponse, error) {
+	result := &models.SharedPhotosResponse{Expires: sh.Expires}
+	ids := sh.PhotoIds
+	if nil != sh.AlbumId {
+		album, err := c.local.getAlbum(*sh.AlbumId)
+		if nil != err {
+			// The album was deleted.
+			return nil, ShareNotFound
+		}
+		result.Name = album.Name
+		ids = album.PhotoIds
+	}
+
+	points, err := c.getPoints(ctx, ids, METADATA_PATH, METADATA_TIMESTAMP, METADATA_MEDIA_TYPE)
+	if nil != err {
+		return nil, err
+	}
+
+	// Don't disclose the folders of shared photos.
+	items := make([]*models.SharedPhotoItem, len(points))
+	for i, item := range makeBrowseResultsResponse(points, nil).Items {
+		items[i] = &models.SharedPhotoItem{
+			Id:        item.Id,
+			Timestamp: item.Timestamp,
+			MediaType: item.MediaType,
+		}
+	}
+
+	order := make(map[string]int, len(ids))
+	for i, id := range ids {
+		order[id] = i
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return order[items[i].Id] < order[items[j].Id]
+	})
+	result.Items = items
+
+	return result, nil
+}
+
+// isSharedPhoto checks if the photo with the given ID is part of the share.
+func (c *serverContext) isSharedPhoto(sh *share, id string) bool {
+	if nil == sh.AlbumId {
+		return slices.Contains(sh.PhotoIds, id)
+	}
+
+	album, err := c.local.getAlbum(*sh.AlbumId)
+	return nil == err && slices.Contains(album.PhotoIds, id)
+}
+
+func uniqueIds(ids []string) []string {
+	var result []string
+	for _, id := range ids {
+		if !slices.Contains(result, id) {
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+func TestShareTokenPurpose(t *testing.T) {
+	initCursorSigningKey("test")
+
+	local, err := openLocalStore(filepath.Join(t.TempDir(), "local.db"))
+	if nil != err {
+		t.Fatalf("openLocalStore() failed: %v", err)
+	}
+	defer local.Close()
+
+	sh := &share{Share: models.Share{
+		Id:          "0123456789abcdef",
+		PhotoIds:    []string{testPointId(1)},
+		HasPassword: true,
+		Expires:     time.Now().Add(time.Hour).Unix(),
+	}}
+	if err := local.putShare(sh); nil != err {
+		t.Fatalf("putShare() failed: %v", err)
+	}
+
+	c := &serverContext{local: local}
+	access := local.makeShareResponse(sh).Token
+	unlock := *signWithKey(local.shareSigningKey, shareToken{Purpose: SHARE_TOKEN_PURPOSE_UNLOCK, Id: sh.Id, Expires: sh.Expires})
+	legacy := *signWithKey(local.shareSigningKey, shareToken{Id: sh.Id, Expires: sh.Expires})
+	cursor := *signCursor(shareToken{Purpose: SHARE_TOKEN_PURPOSE_ACCESS, Id: sh.Id, Expires: sh.Expires})
+
+	if _, err := c.getShareForToken(access); nil != err {
+		t.Errorf("getShareForToken() failed for access token: %v", err)
+	}
+	for _, token := range []string{unlock, legacy, cursor} {
+		if _, err := c.getShareForToken(token); ShareNotFound != err {
+			t.Errorf("getShareForToken(%s) returned %v, expected %v", token, err, ShareNotFound)
+		}
+	}
+
+	tests := []struct {
+		cookie   string
+		expected bool
+	}{
+		{unlock, true},
+		{access, false},
+		{legacy, false},
+		{*signCursor(shareToken{Purpose: SHARE_TOKEN_PURPOSE_UNLOCK, Id: sh.Id, Expires: sh.Expires}), false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/s/"+access, nil)
+		r.Header.Set("Cookie", SHARE_COOKIE_PREFIX+sh.Id+"="+test.cookie)
+		if actual := c.isShareUnlocked(sh, r); test.expected != actual {
+			t.Errorf("isShareUnlocked() with cookie %s = %t, expected %t", test.cookie, actual, test.expected)
+		}
+	}
+}
+
+func TestShareTokenSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local.db")
+	local, err := openLocalStore(path)
+	if nil != err {
+		t.Fatalf("openLocalStore() failed: %v", err)
+	}
+
+	sh := &share{Share: models.Share{
+		Id:       "0123456789abcdef",
+		PhotoIds: []string{testPointId(1)},
+		Expires:  time.Now().Add(time.Hour).Unix(),
+	}}
+	if err := local.putShare(sh); nil != err {
+		t.Fatalf("putShare() failed: %v", err)
+	}
+	initCursorSigningKey("")
+	token := local.makeShareResponse(sh).Token
+	local.Close()
+
+	// A new random cursor key must not affect shares.
+	initCursorSigningKey("")
+	local, err = openLocalStore(path)
+	if nil != err {
+		t.Fatalf("openLocalStore() failed: %v", err)
+	}
+	defer local.Close()
+
+	c := &serverContext{local: local}
+	if _, err := c.getShareForToken(token); nil != err {
+		t.Errorf("getShareForToken() after restart failed: %v", err)
+	}
+}
+
+func TestShareUnlockThrottle(t *testing.T) {
+	throttle := newShareUnlockThrottle()
+	ctx := context.Background()
+
+	for i := 0; i < SHARE_UNLOCK_MAX_FAILURES; i++ {
+		if err := throttle.acquire(ctx, "a"); nil != err {
+			t.Fatalf("acquire() #%d failed: %v", i, err)
+		}
+		throttle.release("a", true)
+	}
+
+	if err := throttle.acquire(ctx, "a"); TooManyUnlockAttempts != err {
+		t.Errorf("acquire() after %d failures returned %v, expected %v",
+			SHARE_UNLOCK_MAX_FAILURES, err, TooManyUnlockAttempts)
+	}
+
+	// Other shares are not affected, and failures expire.
+	if err := throttle.acquire(ctx, "b"); nil != err {
+		t.Errorf("acquire() of other share failed: %v", err)
+	}
+	throttle.release("b", false)
+
+	throttle.failures["a"].since = time.Now().Add(-SHARE_UNLOCK_WINDOW)
+	if err := throttle.acquire(ctx, "a"); nil != err {
+		t.Errorf("acquire() after failures expired failed: %v", err)
+	}
+	throttle.release("a", false)
+
+	// Slots are limited, so waiting for one ends with the context.
+	for i := 0; i < SHARE_UNLOCK_CONCURRENCY; i++ {
+		if err := throttle.acquire(ctx, "c"); nil != err {
+			t.Fatalf("acquire() #%d failed: %v", i, err)
+		}
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := throttle.acquire(cancelled, "c"); context.Canceled != err {
+		t.Errorf("acquire() without free slots returned %v, expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/rokeller/photo-search/srv/web/models"
+)
+
+const (
+	TAGS_PAGE_SIZE = 1000
+	MAX_TAG_LENGTH = 64
+
+	TAGS_MODE_ANY = "any"
+	TAGS_MODE_ALL = "all"
+)
+
+// normalizeTags trims and lowercases the given tags, and removes duplicates.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if "" == tag || utf8.RuneCountInString(tag) > MAX_TAG_LENGTH {
+			return nil, InvalidTags
+		}
+
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized, nil
+}
+
+func getTagsFromPayload(payload map[string]*pb.Value) []string {
+	var tags []string
+	for _, value := range payload[METADATA_TAGS].GetListValue().GetValues() {
+		tags = append(tags, value.GetStringValue())
+	}
+
+	return tags
+}
+
+func makeTagsPayloadValue(tags []string) *pb.Value {
+	values := make([]*pb.Value, len(tags))
+	for i, tag := range tags {
+		values[i] = &pb.Value{Kind: &pb.Value_StringValue{StringValue: tag}}
+	}
+
+	return &pb.Value{
+		Kind: &pb.Value_ListValue{ListValue: &pb.ListValue{Values: values}},
+	}
+}
+
+// getTags gets the tags of the photos with the given IDs. Photos which don't
+// exist are not included.
+func (c *serverContext) getTags(ctx context.Context, ids []string) (map[string][]string, error) {
+	points, err := c.getPoints(ctx, ids, METADATA_TAGS)
+	if nil != err {
+		return nil, err
+	}
+
+	tags := make(map[string][]string, len(points))
+	for _, point := range points {
+		tags[point.Id.GetUuid()] = getTagsFromPayload(point.Payload)
+	}
+
+	return tags, nil
+}
+
+// tagPhotos adds the given tags to, or removes them from, the photos with the
+// given IDs, and gets the number of photos whose tags changed. Photos which
+// don't exist are skipped.
+func (c *serverContext) tagPhotos(
+	ctx context.Context,
+	ids []string,
+	tags []string,
+	remove bool,
+) (*models.TagPhotosResponse, error) {
+	tags, err := normalizeTags(tags)
+	if nil != err {
+		return nil, err
+	} else if len(ids) < 1 || len(tags) < 1 {
+		return nil, InvalidTags
+	}
+
+	c.tagsMutex.Lock()
+	defer c.tagsMutex.Unlock()
+
+	current, err := c.getTags(ctx, ids)
+	if nil != err {
+		return nil, err
+	}
+
+	// Photos ending up with the same tags are updated together.
+	updates := make(map[string][]string)
+	var untagged []string
+	for id, photoTags := range current {
+		var updated []string
+		if remove {
+			updated = slices.DeleteFunc(slices.Clone(photoTags), func(tag string) bool {
+				return slices.Contains(tags, tag)
+			})
+		} else {
+			updated = slices.Clone(photoTags)
+			for _, tag := range tags {
+				if !slices.Contains(updated, tag) {
+					updated = append(updated, tag)
+				}
+			}
+		}
+
+		if len(updated) == len(photoTags) {
+			continue
+		} else if len(updated) < 1 {
+			untagged = append(untagged, id)
+		} else {
+			sort.Strings(updated)
+			key := strings.Join(updated, "\x00")
+			updates[key] = append(updates[key], id)
+		}
+	}
+
+	count := len(untagged)
+	for key, updatedIds := range updates {
+		err := c.store.SetPayload(updatedIds, map[string]*pb.Value{
+			METADATA_TAGS: makeTagsPayloadValue(strings.Split(key, "\x00")),
+		})
+		if nil != err {
+			return nil, err
+		}
+		count += len(updatedIds)
+	}
+
+	if len(untagged) > 0 {
+		if err := c.store.DeletePayload(untagged, []string{METADATA_TAGS}); nil != err {
+			return nil, err
+		}
+	}
+
+	glog.V(1).Infof("Updated tags of %d of %d photo(s).", count, len(ids))
+
+	return &models.TagPhotosResponse{Updated: count}, nil
+}
+
+// listTags lists all tags with the number of photos having them, ordered by
+// descending count, then by tag.
+func (c *serverContext) listTags(ctx context.Context) (*models.TagsResponse, error) {
+	counts := make(map[string]int)
+	params := scrollParams{
+		PageSize: TAGS_PAGE_SIZE,
+		Filter: &pb.Filter{
+			MustNot: []*pb.Condition{{
+				ConditionOneOf: &pb.Condition_IsEmpty{
+					IsEmpty: &pb.IsEmptyCondition{Key: METADATA_TAGS},
+				},
+			}},
+		},
+		Fields: []string{METADATA_TAGS},
+	}
+	for {
+		points, nextOffset, err := c.store.Scroll(ctx, params)
+		if nil != err {
+			return nil, err
+		}
+
+		for _, point := range points {
+			for _, tag := range getTagsFromPayload(point.Payload) {
+				counts[tag]++
+			}
+		}
+
+		if nil == nextOffset {
+			break
+		}
+		params.Offset = nextOffset
+	}
+
+	result := &models.TagsResponse{Tags: make([]*models.TagCount, 0, len(counts))}
+	for tag, count := range counts {
+		result.Tags = append(result.Tags, &models.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(result.Tags, func(i, j int) bool {
+		if result.Tags[i].Count != result.Tags[j].Count {
+			return result.Tags[i].Count > result.Tags[j].Count
+		}
+		return result.Tags[i].Tag < result.Tags[j].Tag
+	})
+
+	return result, nil
+}
+
+// makeTagsCondition makes a condition for photos with any or all of the given
+// (normalized) tags.
+func makeTagsCondition(tags []string, mode string) *pb.Condition {
+	if TAGS_MODE_ALL != mode {
+		return &pb.Condition{
+			ConditionOneOf: &pb.Condition_Field{
+				Field: &pb.FieldCondition{
+					Key: METADATA_TAGS,
+					Match: &pb.Match{
+						MatchValue: &pb.Match_Keywords{
+							Keywords: &pb.RepeatedStrings{Strings: tags},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	must := make([]*pb.Condition, len(tags))
+	for i, tag := range tags {
+		must[i] = &pb.Condition{
+			ConditionOneOf: &pb.Condition_Field{
+				Field: &pb.FieldCondition{
+					Key: METADATA_TAGS,
+					Match: &pb.Match{
+						MatchValue: &pb.Match_Keyword{Keyword: tag},
+					},
+				},
+			},
+		}
+	}
+
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Filter{
+			Filter: &pb.Filter{Must: must},
+		},
+	}
+}
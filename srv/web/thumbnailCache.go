@@ -0,0 +1,252 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// thumbnailCache is a disk-backed cache for rendered thumbnails. The total
+// size of the cached thumbnails is capped; when the cap is exceeded, the
+// least recently used thumbnails are evicted. Concurrent requests for the
+// same thumbnail are coalesced, such that each thumbnail is rendered once.
+type thumbnailCache struct {
+	dir     string
+	maxSize int64
+
+	mutex    sync.Mutex
+	size     int64
+	lru      *list.List // of *thumbnailCacheEntry, most recently used first
+	entries  map[string]*list.Element
+	inflight map[string]*thumbnailCall
+}
+
+type thumbnailCacheEntry struct {
+	name string
+	size int64
+}
+
+type thumbnailCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newThumbnailCache(dir string, maxSize int64) (*thumbnailCache, error) {
+	if err := os.MkdirAll(dir, 0o755); nil != err {
+		return nil, err
+	}
+
+	c := &thumbnailCache{
+		dir:      dir,
+		maxSize:  maxSize,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*thumbnailCall),
+	}
+
+	if err := c.load(); nil != err {
+		return nil, err
+	}
+
+	glog.Infof("Thumbnail cache at '%s' holds %d thumbnail(s) using %d byte(s).",
+		dir, c.lru.Len(), c.size)
+
+	return c, nil
+}
+
+// thumbnailCacheKey builds the cache key for a thumbnail. The key includes the
+// source file's modification time and size, such that modified photos get
+// new thumbnails.
+func thumbnailCacheKey(id string, width int, format *thumbnailFormat, source fs.FileInfo) string {
+	return fmt.Sprintf("%s/%d/%s/%d/%d",
+		id, width, format.name, source.ModTime().UnixNano(), source.Size())
+}
+
+// load rebuilds the LRU list from the files in the cache directory, using
+// their modification time as the time they were last used.
+func (c *thumbnailCache) load() error {
+	type cachedFile struct {
+		entry   *thumbnailCacheEntry
+		lastUse time.Time
+	}
+	var files []cachedFile
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if nil != err || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if nil != err {
+			return err
+		}
+
+		name, err := filepath.Rel(c.dir, path)
+		if nil != err {
+			return err
+		}
+
+		if filepath.Ext(name) == ".tmp" {
+			// Leftover from an interrupted write.
+			return os.Remove(path)
+		}
+
+		files = append(files, cachedFile{
+			entry:   &thumbnailCacheEntry{name: name, size: info.Size()},
+			lastUse: info.ModTime(),
+		})
+
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].lastUse.After(files[j].lastUse)
+	})
+
+	for _, f := range files {
+		c.entries[f.entry.name] = c.lru.PushBack(f.entry)
+		c.size += f.entry.size
+	}
+	c.evict()
+
+	return nil
+}
+
+// Get gets the thumbnail with the given key from the cache. If it is not
+// cached yet, the thumbnail is rendered using render and added to the cache.
+func (c *thumbnailCache) Get(key string, render func() ([]byte, error)) ([]byte, error) {
+	name := c.fileName(key)
+
+	c.mutex.Lock()
+	if elem, found := c.entries[name]; found {
+		c.lru.MoveToFront(elem)
+		c.mutex.Unlock()
+
+		data, err := c.read(name)
+		if nil == err {
+			return data, nil
+		}
+
+		glog.Warningf("Failed to read cached thumbnail '%s': %v", name, err)
+		c.mutex.Lock()
+		c.remove(name)
+	}
+
+	if call, found := c.inflight[name]; found {
+		c.mutex.Unlock()
+		<-call.done
+
+		return call.data, call.err
+	}
+
+	call := &thumbnailCall{done: make(chan struct{})}
+	c.inflight[name] = call
+	c.mutex.Unlock()
+
+	call.data, call.err = render()
+	if nil == call.err {
+		c.store(name, call.data)
+	}
+
+	c.mutex.Lock()
+	delete(c.inflight, name)
+	c.mutex.Unlock()
+	close(call.done)
+
+	return call.data, call.err
+}
+
+func (c *thumbnailCache) fileName(key string) string {
+	hash := sha1.Sum([]byte(key))
+	hexHash := hex.EncodeToString(hash[:])
+
+	// Spread the thumbnails across subdirectories to keep directories small.
+	return filepath.Join(hexHash[:2], hexHash[2:])
+}
+
+func (c *thumbnailCache) read(name string) ([]byte, error) {
+	path := filepath.Join(c.dir, name)
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+
+	// Remember when the thumbnail was last used, for when the cache is loaded
+	// again after a restart.
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); nil != err {
+		glog.V(1).Infof("Failed to touch cached thumbnail '%s': %v", name, err)
+	}
+
+	return data, nil
+}
+
+func (c *thumbnailCache) store(name string, data []byte) {
+	path := filepath.Join(c.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); nil != err {
+		glog.Errorf("Failed to create thumbnail cache directory: %v", err)
+		return
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); nil != err {
+		glog.Errorf("Failed to write thumbnail '%s' to cache: %v", name, err)
+		os.Remove(tmpPath)
+		return
+	}
+	if err := os.Rename(tmpPath, path); nil != err {
+		glog.Errorf("Failed to write thumbnail '%s' to cache: %v", name, err)
+		os.Remove(tmpPath)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.remove(name)
+	entry := &thumbnailCacheEntry{name: name, size: int64(len(data))}
+	c.entries[name] = c.lru.PushFront(entry)
+	c.size += entry.size
+	c.evict()
+}
+
+// remove forgets about the thumbnail with the given name; the caller must
+// hold the mutex.
+func (c *thumbnailCache) remove(name string) {
+	elem, found := c.entries[name]
+	if !found {
+		return
+	}
+
+	entry := c.lru.Remove(elem).(*thumbnailCacheEntry)
+	delete(c.entries, name)
+	c.size -= entry.size
+}
+
+// evict removes the least recently used thumbnails until the cache is within
+// its size cap again; the caller must hold the mutex.
+func (c *thumbnailCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		entry := c.lru.Back().Value.(*thumbnailCacheEntry)
+		c.remove(entry.name)
+
+		if err := os.Remove(filepath.Join(c.dir, entry.name)); nil != err {
+			glog.Warningf("Failed to evict thumbnail '%s': %v", entry.name, err)
+		} else {
+			glog.V(2).Infof("Evicted thumbnail '%s' from cache.", entry.name)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MAX_THUMBNAIL_WIDTH is the largest width clients may request for a
+	// thumbnail; anything beyond is rejected.
+	MAX_THUMBNAIL_WIDTH = 8192
+)
+
+// parseThumbnailWidths parses a comma-separated list of thumbnail widths and
+// returns them in ascending order.
+func parseThumbnailWidths(s string) ([]int, error) {
+	var widths []int
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if "" == part {
+			continue
+		}
+
+		width, err := strconv.Atoi(part)
+		if nil != err {
+			return nil, fmt.Errorf("invalid thumbnail width '%s': %w", part, err)
+		} else if width < 1 || width > MAX_THUMBNAIL_WIDTH {
+			return nil, fmt.Errorf("thumbnail width %d is out of range [1, %d]",
+				width, MAX_THUMBNAIL_WIDTH)
+		}
+
+		widths = append(widths, width)
+	}
+
+	if len(widths) < 1 {
+		return nil, errors.New("no thumbnail widths given")
+	}
+
+	sort.Ints(widths)
+
+	return widths, nil
+}
+
+// snapThumbnailWidth snaps the requested width to the smallest of the given
+// (ascending) widths that is at least as large as the requested width, or to
+// the largest of the widths if none is large enough.
+func snapThumbnailWidth(requested int, widths []int) int {
+	i := sort.SearchInts(widths, requested)
+	if i >= len(widths) {
+		return widths[len(widths)-1]
+	}
+
+	return widths[i]
+}
+
+// thumbnailFormat describes an image format in which thumbnails can be
+// encoded.
+type thumbnailFormat struct {
+	name     string
+	mimeType string
+	// preference ranks the format; if a client accepts multiple formats, the
+	// one with the highest preference is used.
+	preference int
+	quality    *int
+	encode     func(img image.Image, quality int) ([]byte, error)
+}
+
+var (
+	jpegThumbnailFormat = &thumbnailFormat{
+		name:       "jpeg",
+		mimeType:   "image/jpeg",
+		preference: 0,
+		quality:    jpegQuality,
+		encode: func(img image.Image, quality int) ([]byte, error) {
+			var buf bytes.Buffer
+			err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+			return buf.Bytes(), err
+		},
+	}
+
+	// thumbnailFormats holds the supported thumbnail formats, ordered by
+	// descending preference.
+	thumbnailFormats = []*thumbnailFormat{jpegThumbnailFormat}
+)
+
+// registerThumbnailFormat adds support for another thumbnail format.
+func registerThumbnailFormat(format *thumbnailFormat) {
+	thumbnailFormats = append(thumbnailFormats, format)
+	sort.SliceStable(thumbnailFormats, func(i, j int) bool {
+		return thumbnailFormats[i].preference > thumbnailFormats[j].preference
+	})
+}
+
+// negotiateThumbnailFormat picks the most preferred thumbnail format that is
+// explicitly accepted according to the given Accept header. Wildcards like
+// 'image/*' only ever select JPEG, which is supported by all clients.
+func negotiateThumbnailFormat(accept string) *thumbnailFormat {
+	accepted := make(map[string]bool)
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mimeType, params, _ := strings.Cut(mediaRange, ";")
+		mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+		accepted[mimeType] = !isZeroQuality(params)
+	}
+
+	for _, format := range thumbnailFormats {
+		if accepted[format.mimeType] {
+			return format
+		}
+	}
+
+	return jpegThumbnailFormat
+}
+
+// isZeroQuality checks if the parameters of a media range in an Accept header
+// have a quality value of zero, i.e. mark the media range as not acceptable.
+func isZeroQuality(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if "q" == strings.ToLower(name) {
+			q, err := strconv.ParseFloat(value, 64)
+			return nil == err && 0 == q
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseThumbnailWidths(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []int
+		valid    bool
+	}{
+		{"160,320,640", []int{160, 320, 640}, true},
+		{" 640, 160 ,,320 ", []int{160, 320, 640}, true},
+		{"8192", []int{8192}, true},
+		{"", nil, false},
+		{"160,abc", nil, false},
+		{"0,160", nil, false},
+		{"8193", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			actual, err := parseThumbnailWidths(test.input)
+			if test.valid != (nil == err) {
+				t.Fatalf("parseThumbnailWidths() error = %v, expected valid = %t", err, test.valid)
+			}
+			if !slices.Equal(test.expected, actual) {
+				t.Errorf("parseThumbnailWidths() = %v, expected %v", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestSnapThumbnailWidth(t *testing.T) {
+	widths := []int{160, 320, 640, 1280}
+
+	tests := []struct {
+		requested int
+		expected  int
+	}{
+		{1, 160},
+		{160, 160},
+		{161, 320},
+		{640, 640},
+		{1000, 1280},
+		{1280, 1280},
+		{4000, 1280},
+	}
+
+	for _, test := range tests {
+		if actual := snapThumbnailWidth(test.requested, widths); test.expected != actual {
+			t.Errorf("snapThumbnailWidth(%d) = %d, expected %d", test.requested, actual, test.expected)
+		}
+	}
+}
+
+func TestNegotiateThumbnailFormat(t *testing.T) {
+	// WebP is only supported in builds with cgo.
+	webp := jpegThumbnailFormat
+	for _, format := range thumbnailFormats {
+		if "image/webp" == format.mimeType {
+			webp = format
+		}
+	}
+
+	tests := []struct {
+		accept   string
+		expected *thumbnailFormat
+	}{
+		{"", jpegThumbnailFormat},
+		{"*/*", jpegThumbnailFormat},
+		{"image/*", jpegThumbnailFormat},
+		{"image/jpeg", jpegThumbnailFormat},
+		{"image/webp", webp},
+		{"image/avif,image/webp,image/apng,*/*;q=0.8", webp},
+		{"IMAGE/WEBP;q=0.5, image/jpeg", webp},
+		{"image/webp;q=0, image/jpeg", jpegThumbnailFormat},
+		{"image/webp; q=0.0", jpegThumbnailFormat},
+		{"image/png", jpegThumbnailFormat},
+	}
+
+	for _, test := range tests {
+		if actual := negotiateThumbnailFormat(test.accept); test.expected != actual {
+			t.Errorf("negotiateThumbnailFormat('%s') = %s, expected %s",
+				test.accept, actual.name, test.expected.name)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build cgo
+
+package main
+
+import (
+	"image"
+
+	"github.com/chai2010/webp"
+)
+
+func init() {
+	registerThumbnailFormat(&thumbnailFormat{
+		name:       "webp",
+		mimeType:   "image/webp",
+		preference: 10,
+		quality:    webpQuality,
+		encode: func(img image.Image, quality int) ([]byte, error) {
+			return webp.EncodeRGB(img, float32(quality))
+		},
+	})
+}
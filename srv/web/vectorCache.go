@@ -0,0 +1,81 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"sync"
+)
+
+// vectorCache keeps the most recently used query vectors by their hash, such
+// that following pages of search results don't need to compute them again.
+type vectorCache struct {
+	capacity int
+
+	mutex   sync.Mutex
+	lru     *list.List // of *vectorCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type vectorCacheEntry struct {
+	hash   string
+	vector []float32
+}
+
+func newVectorCache(capacity int) *vectorCache {
+	return &vectorCache{
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// hashVector calculates a hash of the given vector.
+func hashVector(vector []float32) string {
+	h := sha256.New()
+	buf := make([]byte, 4)
+	for _, x := range vector {
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(x))
+		h.Write(buf)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// put adds the vector to the cache and returns its hash.
+func (c *vectorCache) put(vector []float32) string {
+	hash := hashVector(vector)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, found := c.entries[hash]; found {
+		c.lru.MoveToFront(elem)
+		return hash
+	}
+
+	c.entries[hash] = c.lru.PushFront(&vectorCacheEntry{hash: hash, vector: vector})
+	for c.lru.Len() > c.capacity {
+		entry := c.lru.Remove(c.lru.Back()).(*vectorCacheEntry)
+		delete(c.entries, entry.hash)
+	}
+
+	return hash
+}
+
+// get gets the vector with the given hash, or nil if it isn't cached.
+func (c *vectorCache) get(hash string) []float32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, found := c.entries[hash]
+	if !found {
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return elem.Value.(*vectorCacheEntry).vector
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+const (
+	// VECTOR_SIZE is the dimension of the embeddings produced by the model.
+	VECTOR_SIZE = 512
+)
+
+// VectorStore abstracts the storage of photo embeddings and their payloads,
+// as well as the similarity search over them.
+type VectorStore interface {
+	// EnsureCollection makes sure the store is ready to be used, creating
+	// the underlying collection if needed.
+	EnsureCollection() error
+	// Close releases all resources held by the store.
+	Close() error
+
+	// Scroll pages through the points in the store, ordered by their ID. It
+	// returns the points of the page starting at the offset, and the offset of
+	// the next page, if any. If the points are ordered by a payload field
+	// instead, the offset is ignored and no next offset is returned.
+	Scroll(ctx context.Context, params scrollParams) ([]*pb.RetrievedPoint, *string, error)
+	// Upsert inserts or replaces the given points.
+	Upsert(points []*pb.PointStruct) error
+	// Delete removes the points with the given IDs.
+	Delete(ids []string) error
+	// GetPayload gets the payload of the point with the given ID.
+	GetPayload(id string) (map[string]*pb.Value, error)
+	// GetPoints gets the points with the given IDs, with the given payload
+	// fields, or the full payload if none are given. Points which don't exist
+	// are skipped.
+	GetPoints(ctx context.Context, ids []string, fields []string) ([]*pb.RetrievedPoint, error)
+	// SetPayload sets the given payload fields of the points with the given
+	// IDs, keeping their other fields.
+	SetPayload(ids []string, payload map[string]*pb.Value) error
+	// DeletePayload removes the payload fields with the given keys from the
+	// points with the given IDs.
+	DeletePayload(ids []string, keys []string) error
+
+	// Search finds the points most similar to the given vector.
+	Search(vector []float32, params searchParams) ([]*pb.ScoredPoint, error)
+	// Recommend finds the points most similar to the positive examples and
+	// least similar to the negative ones, excluding the example points.
+	Recommend(examples recommendExamples, params searchParams) ([]*pb.ScoredPoint, error)
+	// RecommendBatch runs a recommendation for each of the given examples in a
+	// single request. The results have IDs and scores, but no payloads.
+	RecommendBatch(examples []recommendExamples, params searchParams) ([][]*pb.ScoredPoint, error)
+}
+
+// scrollParams holds the parameters for paging through points.
+type scrollParams struct {
+	PageSize uint32
+	Offset   *string
+	Filter   *pb.Filter
+	// Fields holds the payload fields to include; if empty, the full payload
+	// is included.
+	Fields []string
+	// OrderBy optionally orders the points by a numeric payload field; points
+	// without a value for that field are skipped.
+	OrderBy *pb.OrderBy
+}
+
+// recommendExamples holds the examples to recommend similar points for, both
+// as point IDs and as vectors, and the strategy to combine them.
+type recommendExamples struct {
+	Positive        []string
+	Negative        []string
+	PositiveVectors [][]float32
+	NegativeVectors [][]float32
+	Strategy        pb.RecommendStrategy
+}
+
+// recommendStrategies maps the names of the recommendation strategies used in
+// requests to the strategies of the vector store.
+var recommendStrategies = map[string]pb.RecommendStrategy{
+	"":              pb.RecommendStrategy_AverageVector,
+	"averageVector": pb.RecommendStrategy_AverageVector,
+	"bestScore":     pb.RecommendStrategy_BestScore,
+}
+
+// searchParams holds the parameters common to all similarity searches.
+type searchParams struct {
+	Limit          uint64
+	Offset         uint64
+	Filter         *pb.Filter
+	ScoreThreshold *float32
+}
+
+func makePointId(id string) *pb.PointId {
+	return &pb.PointId{
+		PointIdOptions: &pb.PointId_Uuid{Uuid: id},
+	}
+}
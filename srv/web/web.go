@@ -3,41 +3,123 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 )
 
 var (
+	vectorStore = flag.String("store", "qdrant",
+		"The vector store to use; either 'qdrant' or 'memory'.")
+	memoryStoreFile = flag.String("memory-store-file", "data/photos.db",
+		"The file in which the 'memory' vector store persists the photo vectors.")
 	qdrantAddr = flag.String("qdrant-addr", "qdrant:6334",
 		"The address of the qdrant server to connect to.")
 	qdrantColl = flag.String("qdrant-coll", "photos",
 		"The name of the qdrant collection to use.")
 	embeddingsServiceBaseUrl = flag.String("mbed", "http://localhost:8082/",
 		"The base address of the service calculating embeddings for queries.")
+	imageEmbeddingsUrl = flag.String("image-mbed", "",
+		"The URL of the service calculating embeddings for images posted to it; the embedding server's '/v1/embed/image' endpoint is used if empty.")
 	photosRootDir = flag.String("photos", "",
 		"The root directory where the photos are located.")
+	cursorSecret = flag.String("cursor-secret", "",
+		"The secret used to sign pagination cursors and share tokens; a random one is used if empty.")
+	thumbnailWidths = flag.String("thumbnail-widths", "160,320,640,1280,2560",
+		"The comma-separated widths to which requested thumbnail widths are snapped.")
+	jpegQuality = flag.Int("jpeg-quality", 66,
+		"The quality (1-100) of JPEG thumbnails.")
+	webpQuality = flag.Int("webp-quality", 60,
+		"The quality (0-100) of WebP thumbnails; WebP requires a build with cgo.")
+	ffmpegPath = flag.String("ffmpeg", "ffmpeg",
+		"The path to the ffmpeg binary used to extract poster frames from videos.")
+	exifAllowTags = flag.String("exif-allow", "",
+		"The comma-separated EXIF tags exposed in photo details; all tags are exposed if empty.")
+	exifDenyTags = flag.String("exif-deny", "BodySerialNumber,LensSerialNumber,CameraOwnerName,ImageUniqueID,MakerNote",
+		"The comma-separated EXIF tags never exposed in photo details.")
+	gazetteerCities = flag.String("gazetteer", "gazetteer/cities15000.txt",
+		"The GeoNames cities dump used to resolve photo locations to place names; disabled if empty.")
+	duplicateThreshold = flag.Float64("duplicate-threshold", 0.95,
+		"The minimum cosine similarity of photos to be considered near-duplicates.")
+	duplicatesInterval = flag.Duration("duplicates-interval", time.Hour,
+		"The interval at which near-duplicates are detected if photos were indexed; disabled if 0.")
+	localDb = flag.String("local-db", "data/local.db",
+		"The file in which albums and ratings are stored.")
+	thumbnailCacheDir = flag.String("thumbnail-cache", "cache/thumbnails",
+		"The directory in which to cache thumbnails; caching is disabled if empty.")
+	thumbnailCacheSize = flag.Int64("thumbnail-cache-size", 1024,
+		"The maximum size of the thumbnail cache, in MiB.")
 )
 
 func main() {
 	flag.Parse()
 
-	glog.Infof("Trying to connect to qdrant at '%s', using collection '%s' ...",
-		*qdrantAddr, *qdrantColl)
-
 	// TODO: validate flags
 
-	srv, err := newServerContext(*qdrantAddr,
-		*qdrantColl,
+	initCursorSigningKey(*cursorSecret)
+
+	widths, err := parseThumbnailWidths(*thumbnailWidths)
+	if nil != err {
+		glog.Exitf("Invalid thumbnail widths: %v", err)
+	}
+	if *jpegQuality < 1 || *jpegQuality > 100 {
+		glog.Exitf("Invalid JPEG quality %d, must be between 1 and 100.", *jpegQuality)
+	}
+	if *webpQuality < 0 || *webpQuality > 100 {
+		glog.Exitf("Invalid WebP quality %d, must be between 0 and 100.", *webpQuality)
+	}
+
+	store, err := newVectorStore()
+	if nil != err {
+		glog.Exitf("Failed to create vector store: %v", err)
+	}
+	defer store.Close()
+
+	var thumbnails *thumbnailCache
+	if "" != *thumbnailCacheDir {
+		thumbnails, err = newThumbnailCache(*thumbnailCacheDir, *thumbnailCacheSize<<20)
+		if nil != err {
+			glog.Errorf("Failed to open thumbnail cache, thumbnails will not be cached: %v", err)
+		}
+	}
+
+	var places *gazetteer
+	if "" != *gazetteerCities {
+		places, err = loadGazetteer(*gazetteerCities)
+		if errors.Is(err, os.ErrNotExist) {
+			glog.Warningf("No gazetteer found, photo locations will not be resolved to places: %v", err)
+		} else if nil != err {
+			glog.Errorf("Failed to load gazetteer, photo locations will not be resolved to places: %v", err)
+		}
+	}
+
+	local, err := openLocalStore(*localDb)
+	if nil != err {
+		glog.Exitf("Failed to open local database '%s': %v", *localDb, err)
+	}
+	defer local.Close()
+
+	srv, err := newServerContext(store,
+		thumbnails,
+		widths,
+		newExifTagPolicy(*exifAllowTags, *exifDenyTags),
+		places,
+		local,
 		*embeddingsServiceBaseUrl,
+		*imageEmbeddingsUrl,
 		*photosRootDir)
 	if nil != err {
-		glog.Exitf("Failed to connect to qdrant collection: %v", err)
+		glog.Exitf("Failed to open vector store collection: %v", err)
+	}
+
+	if *duplicatesInterval > 0 {
+		go srv.runDuplicateDetection(*duplicatesInterval, float32(*duplicateThreshold))
 	}
-	defer srv.conn.Close()
 
 	publicSrv := NewPublicServer(srv)
 	internalSrv := NewInternalServer(srv)
@@ -58,6 +140,22 @@ func main() {
 	glog.V(0).Info("Got signal:", s)
 }
 
+func newVectorStore() (VectorStore, error) {
+	switch *vectorStore {
+	case "qdrant":
+		glog.Infof("Trying to connect to qdrant at '%s', using collection '%s' ...",
+			*qdrantAddr, *qdrantColl)
+		return newQdrantStore(*qdrantAddr, *qdrantColl)
+
+	case "memory":
+		glog.Infof("Using in-memory vector store persisted at '%s' ...", *memoryStoreFile)
+		return newMemoryStore(*memoryStoreFile), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported vector store '%s'", *vectorStore)
+	}
+}
+
 func serveHTTP(server *http.Server) {
 	if err := server.ListenAndServe(); nil != err {
 		if errors.Is(err, http.ErrServerClosed) {